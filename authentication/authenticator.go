@@ -7,3 +7,9 @@ type Authenticator interface {
 	IsAuthenticated(r *http.Request) bool
 	Authenticate(w http.ResponseWriter, r *http.Request)
 }
+
+// UserIdentifier is implemented by authenticators able to tell
+// the name of authenticated user
+type UserIdentifier interface {
+	User(r *http.Request) string
+}
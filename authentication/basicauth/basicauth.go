@@ -10,6 +10,7 @@ import (
 )
 
 var _ authentication.Authenticator = &BasicAuth{}
+var _ authentication.UserIdentifier = &BasicAuth{}
 
 // Backend is an interface for underlying authentication storages
 // example for such storage could be: htpasswd file, sql database, PAM, etc.
@@ -74,3 +75,9 @@ func (ba *BasicAuth) Authenticate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", `Basic realm="Restricted area"`)
 	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 }
+
+// User returns the name of the user passed in request credentials
+func (ba *BasicAuth) User(r *http.Request) string {
+	username, _, _ := r.BasicAuth()
+	return username
+}
@@ -56,6 +56,18 @@ func (s *BasicAuthTestSuite) TestAuthenticationWithValidCredentials() {
 	s.Equal(`Basic realm="Restricted area"`, resp.Header.Get("WWW-Authenticate"))
 }
 
+func (s *BasicAuthTestSuite) TestUser() {
+	r, err := http.NewRequest("GET", "/", nil)
+	s.Require().NoError(err)
+
+	ui, ok := s.basicAuth.(authentication.UserIdentifier)
+	s.Require().True(ok)
+	s.Equal("", ui.User(r))
+
+	r.SetBasicAuth(testUsername, testPassword)
+	s.Equal(testUsername, ui.User(r))
+}
+
 func (s *BasicAuthTestSuite) TestAuthenticationWithInvalidPassword() {
 	r, err := http.NewRequest("GET", "/", nil)
 	s.Require().NoError(err)
@@ -126,6 +126,14 @@ func (c *Cache) Delete(ctx context.Context, key string) error {
 	return c.backend.Delete(ctx, key)
 }
 
+// Close closes backend's connections if backend holds any
+func (c *Cache) Close() error {
+	if closer, ok := c.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func (c *Cache) decrypt(ciphertext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(c.encryptionKey)
 	if err != nil {
@@ -40,3 +40,8 @@ func (c *Cache) Put(ctx context.Context, key string, data []byte) error {
 func (c *Cache) Delete(ctx context.Context, key string) error {
 	return c.client.Del(key).Err()
 }
+
+// Close closes Redis client connections
+func (c *Cache) Close() error {
+	return c.client.Close()
+}
@@ -18,6 +18,7 @@ var _ autocert.Cache = &Cache{}
 
 // Cache implements autocert.Cache with MySQL database
 type Cache struct {
+	db     *sql.DB
 	driver autocert.Cache
 }
 
@@ -47,6 +48,7 @@ func NewCache(db *sql.DB) (*Cache, error) {
 	}
 
 	return &Cache{
+		db:     db,
 		driver: driver,
 	}, nil
 }
@@ -98,3 +100,8 @@ func (m *Cache) encode(input []byte) []byte {
 
 	return ct
 }
+
+// Close closes database connections
+func (m *Cache) Close() error {
+	return m.db.Close()
+}
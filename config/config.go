@@ -42,22 +42,80 @@ type ListenerBackend struct {
 	TLSHandshakeTimeout   time.Duration `yaml:"tlsHandshakeTimeout" default:"3s"`
 }
 
+// ListenerAdmin configuration
+type ListenerAdmin struct {
+	Token string `yaml:"token"`
+}
+
 // ListenerFrontend configuration
 type ListenerFrontend struct {
 	IdleTimeout       time.Duration `yaml:"idleTimeout" default:"5s"`
 	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" default:"3s"`
 	ReadTimeout       time.Duration `yaml:"readTimeout" default:"10s"`
 	WriteTimeout      time.Duration `yaml:"writeTimeout" default:"10s"`
+	H2C               bool          `yaml:"h2c"`
+}
+
+// ListenerForwarding configuration
+type ListenerForwarding struct {
+	TrustedProxies []string `yaml:"trustedProxies"`
+	Headers        string   `yaml:"headers" default:"xforwarded"`
+}
+
+// ListenerProxyProtocol configuration
+type ListenerProxyProtocol struct {
+	Enabled        bool          `yaml:"enabled"`
+	Required       bool          `yaml:"required"`
+	TrustedSources []string      `yaml:"trustedSources"`
+	HeaderTimeout  time.Duration `yaml:"headerTimeout" default:"5s"`
+}
+
+// ListenerDefaultService configuration
+type ListenerDefaultService struct {
+	Action       string          `yaml:"action"`
+	Backend      *ServiceBackend `yaml:"backend"`
+	RedirectURL  string          `yaml:"redirectURL"`
+	PreservePath bool            `yaml:"preservePath"`
+	StatusCode   int             `yaml:"statusCode"`
+	File         string          `yaml:"file"`
+	ContentType  string          `yaml:"contentType"`
+}
+
+// ListenerFallbackCertificate configuration
+type ListenerFallbackCertificate struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// ErrorPages configuration, pages are keyed by status code or `default`
+// and defined by paths to template files
+type ErrorPages struct {
+	HTML              map[string]string `yaml:"html"`
+	JSON              map[string]string `yaml:"json"`
+	InterceptUpstream bool              `yaml:"interceptUpstream"`
+}
+
+// ListenerShutdown configuration
+type ListenerShutdown struct {
+	PreStopDelay time.Duration `yaml:"preStopDelay" default:"0s"`
+	DrainTimeout time.Duration `yaml:"drainTimeout" default:"30s"`
 }
 
 // Listener section of the configuration
 type Listener struct {
-	Backend     ListenerBackend          `yaml:"backend"`
-	DebugAddr   string                   `yaml:"debugAddr" default:"8081"`
-	Frontend    ListenerFrontend         `yaml:"frontend"`
-	HTTPAddr    string                   `yaml:"httpAddr" default:":80"`
-	HTTPSAddr   string                   `yaml:"httpsAddr" default:":443"`
-	Middlewares []map[string]interface{} `yaml:"middlewares"`
+	Admin               ListenerAdmin                `yaml:"admin"`
+	Backend             ListenerBackend              `yaml:"backend"`
+	DebugAddr           string                       `yaml:"debugAddr" default:"8081"`
+	DefaultService      *ListenerDefaultService      `yaml:"defaultService"`
+	ErrorPages          *ErrorPages                  `yaml:"errorPages"`
+	FallbackCertificate *ListenerFallbackCertificate `yaml:"fallbackCertificate"`
+	Forwarding          ListenerForwarding           `yaml:"forwarding"`
+	Frontend            ListenerFrontend             `yaml:"frontend"`
+	HTTPAddr            string                       `yaml:"httpAddr" default:":80"`
+	HTTPSAddr           string                       `yaml:"httpsAddr" default:":443"`
+	Middlewares         []map[string]interface{}     `yaml:"middlewares"`
+	ProxyProtocol       ListenerProxyProtocol        `yaml:"proxyProtocol"`
+	Shutdown            ListenerShutdown             `yaml:"shutdown"`
 }
 
 // Logger section of the configuration
@@ -68,15 +126,157 @@ type Logger struct {
 
 // ServiceFrontend configuration
 type ServiceFrontend struct {
-	FQDN                []string          `yaml:"fqdn"`
-	HTTPHandler         string            `yaml:"httpHandler"`
-	ResponseHTTPHeaders map[string]string `yaml:"responseHTTPHeaders"`
+	FQDN                []string            `yaml:"fqdn"`
+	HTTPHandler         string              `yaml:"httpHandler"`
+	ResponseHTTPHeaders map[string]string   `yaml:"responseHTTPHeaders"`
+	ResponseHeaders     []ServiceHeaderRule `yaml:"responseHeaders"`
+}
+
+// ServiceHeaderRule configuration
+type ServiceHeaderRule struct {
+	Name   string `yaml:"name"`
+	Value  string `yaml:"value"`
+	Action string `yaml:"action"`
+}
+
+// ServiceBackendTarget configuration
+type ServiceBackendTarget struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// ServiceBackendBalancer configuration
+type ServiceBackendBalancer struct {
+	Method  string            `yaml:"method"`
+	Options map[string]string `yaml:"options"`
+}
+
+// ServiceBackendHealthCheck configuration
+type ServiceBackendHealthCheck struct {
+	Type               string        `yaml:"type"`
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthyThreshold"`
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold"`
+	ExpectedStatus     []int         `yaml:"expectedStatus"`
+}
+
+// ServiceBackendCircuitBreaker configuration
+type ServiceBackendCircuitBreaker struct {
+	ConsecutiveFailures int           `yaml:"consecutiveFailures"`
+	CoolDown            time.Duration `yaml:"coolDown"`
+	HalfOpenRequests    int           `yaml:"halfOpenRequests"`
+}
+
+// ServiceBackendRetryPolicy configuration
+type ServiceBackendRetryPolicy struct {
+	MaxAttempts   int           `yaml:"maxAttempts"`
+	RetryOn       []string      `yaml:"retryOn"`
+	StatusCodes   []int         `yaml:"statusCodes"`
+	PerTryTimeout time.Duration `yaml:"perTryTimeout"`
+	Budget        float64       `yaml:"budget"`
+	MaxBodySize   int64         `yaml:"maxBodySize"`
+}
+
+// ServiceBackendAffinity configuration
+type ServiceBackendAffinity struct {
+	Cookie   string        `yaml:"cookie"`
+	TTL      time.Duration `yaml:"ttl"`
+	Secure   bool          `yaml:"secure"`
+	SameSite string        `yaml:"sameSite"`
+	Secret   string        `yaml:"secret"`
+}
+
+// ServiceBackendStatic configuration
+type ServiceBackendStatic struct {
+	IndexFiles       []string `yaml:"indexFiles"`
+	SPAFallback      bool     `yaml:"spaFallback"`
+	DirectoryListing bool     `yaml:"directoryListing"`
+	Precompressed    bool     `yaml:"precompressed"`
+}
+
+// ServiceBackendTLS configuration
+type ServiceBackendTLS struct {
+	CAFile             string   `yaml:"caFile"`
+	CertFile           string   `yaml:"certFile"`
+	KeyFile            string   `yaml:"keyFile"`
+	ServerName         string   `yaml:"serverName"`
+	MinVersion         string   `yaml:"minVersion"`
+	Pins               []string `yaml:"pins"`
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify"`
 }
 
 // ServiceBackend configuration
 type ServiceBackend struct {
-	URL                string            `yaml:"url"`
-	RequestHTTPHeaders map[string]string `yaml:"requestHTTPHeaders" default:"nil"`
+	URL                string                        `yaml:"url"`
+	Targets            []ServiceBackendTarget        `yaml:"targets"`
+	Balancer           ServiceBackendBalancer        `yaml:"balancer"`
+	HealthCheck        *ServiceBackendHealthCheck    `yaml:"healthCheck"`
+	CircuitBreaker     *ServiceBackendCircuitBreaker `yaml:"circuitBreaker"`
+	RetryPolicy        *ServiceBackendRetryPolicy    `yaml:"retryPolicy"`
+	Affinity           *ServiceBackendAffinity       `yaml:"affinity"`
+	RequestHTTPHeaders map[string]string             `yaml:"requestHTTPHeaders" default:"nil"`
+	RequestHeaders     []ServiceHeaderRule           `yaml:"requestHeaders"`
+	ProxyProtocol      int                           `yaml:"proxyProtocol"`
+	Static             *ServiceBackendStatic         `yaml:"static"`
+	TLS                *ServiceBackendTLS            `yaml:"tls"`
+	Protocol           string                        `yaml:"protocol"`
+}
+
+// ServiceRewrite configuration
+type ServiceRewrite struct {
+	StripPrefix string            `yaml:"stripPrefix"`
+	AddPrefix   string            `yaml:"addPrefix"`
+	Regex       string            `yaml:"regex"`
+	Replacement string            `yaml:"replacement"`
+	AddQuery    map[string]string `yaml:"addQuery"`
+	RemoveQuery []string          `yaml:"removeQuery"`
+}
+
+// ServiceResponseRewriteRule configuration
+type ServiceResponseRewriteRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// ServiceResponseRewrite configuration
+type ServiceResponseRewrite struct {
+	TargetRedirects bool                         `yaml:"targetRedirects"`
+	Redirects       []ServiceResponseRewriteRule `yaml:"redirects"`
+	CookieDomains   []ServiceResponseRewriteRule `yaml:"cookieDomains"`
+	CookiePaths     []ServiceResponseRewriteRule `yaml:"cookiePaths"`
+}
+
+// ServiceRoute configuration
+type ServiceRoute struct {
+	Path    string          `yaml:"path"`
+	Match   string          `yaml:"match"`
+	Backend ServiceBackend  `yaml:"backend"`
+	Rewrite *ServiceRewrite `yaml:"rewrite"`
+}
+
+// ServiceBackendGroup configuration
+type ServiceBackendGroup struct {
+	Name    string         `yaml:"name"`
+	Weight  int            `yaml:"weight"`
+	Backend ServiceBackend `yaml:"backend"`
+}
+
+// ServiceGroupOverride configuration
+type ServiceGroupOverride struct {
+	Header string `yaml:"header"`
+	Cookie string `yaml:"cookie"`
+	Query  string `yaml:"query"`
+	Value  string `yaml:"value"`
+	Group  string `yaml:"group"`
+}
+
+// ServiceMirror configuration
+type ServiceMirror struct {
+	Backend     ServiceBackend `yaml:"backend"`
+	Percentage  float64        `yaml:"percentage"`
+	MaxBodySize int64          `yaml:"maxBodySize"`
 }
 
 // ServiceAuthentication configuration
@@ -85,11 +285,44 @@ type ServiceAuthentication struct {
 	Options map[string]string `yaml:"options"`
 }
 
+// ServiceMaintenanceWindow configuration, timestamps are in RFC3339 format
+type ServiceMaintenanceWindow struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// ServiceMaintenanceBypass configuration
+type ServiceMaintenanceBypass struct {
+	Networks []string `yaml:"networks"`
+	Header   string   `yaml:"header"`
+	Cookie   string   `yaml:"cookie"`
+	Secret   string   `yaml:"secret"`
+}
+
+// ServiceMaintenance configuration
+type ServiceMaintenance struct {
+	Enabled     bool                       `yaml:"enabled"`
+	Windows     []ServiceMaintenanceWindow `yaml:"windows"`
+	RetryAfter  time.Duration              `yaml:"retryAfter"`
+	Page        string                     `yaml:"page"`
+	ContentType string                     `yaml:"contentType"`
+	Bypass      ServiceMaintenanceBypass   `yaml:"bypass"`
+}
+
 // Service section of the configuration
 type Service struct {
-	Frontend       ServiceFrontend       `yaml:"frontend"`
-	Backend        ServiceBackend        `yaml:"backend"`
-	Authentication ServiceAuthentication `yaml:"authentication"`
+	Name            string                  `yaml:"name"`
+	Frontend        ServiceFrontend         `yaml:"frontend"`
+	Backend         ServiceBackend          `yaml:"backend"`
+	Routes          []ServiceRoute          `yaml:"routes"`
+	BackendGroups   []ServiceBackendGroup   `yaml:"backendGroups"`
+	GroupOverrides  []ServiceGroupOverride  `yaml:"groupOverrides"`
+	Mirror          *ServiceMirror          `yaml:"mirror"`
+	Rewrite         *ServiceRewrite         `yaml:"rewrite"`
+	ResponseRewrite *ServiceResponseRewrite `yaml:"responseRewrite"`
+	Authentication  ServiceAuthentication   `yaml:"authentication"`
+	ErrorPages      *ErrorPages             `yaml:"errorPages"`
+	Maintenance     *ServiceMaintenance     `yaml:"maintenance"`
 }
 
 // Load reads YAML configuration file and returns Config
@@ -102,6 +335,17 @@ func Load(path string) (*Config, error) {
 	return parse(spec)
 }
 
+// ParseService parses YAML or JSON service definition
+func ParseService(spec []byte) (*Service, error) {
+	var service Service
+	err := yaml.UnmarshalStrict(spec, &service)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service, nil
+}
+
 func read(path string) ([]byte, error) {
 	spec, err := ioutil.ReadFile(path)
 	if err != nil {
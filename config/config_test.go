@@ -34,6 +34,36 @@ func (s *ConfigTestSuite) TestConfig() {
 				ResponseHeaderTimeout: 10 * time.Second,
 				TLSHandshakeTimeout:   10 * time.Second,
 			},
+			DefaultService: &ListenerDefaultService{
+				Action:      "redirect",
+				RedirectURL: "https://example.com/",
+				StatusCode:  301,
+			},
+			FallbackCertificate: &ListenerFallbackCertificate{
+				CertFile: "/etc/svcproxy/tls/default.crt",
+				KeyFile:  "/etc/svcproxy/tls/default.key",
+			},
+			ErrorPages: &ErrorPages{
+				HTML: map[string]string{
+					"default": "/etc/svcproxy/errors/default.html",
+					"503":     "/etc/svcproxy/errors/503.html",
+				},
+				JSON: map[string]string{
+					"default": "/etc/svcproxy/errors/default.json",
+				},
+			},
+			Forwarding: ListenerForwarding{
+				TrustedProxies: []string{"10.0.0.0/8"},
+				Headers:        "xforwarded",
+			},
+			ProxyProtocol: ListenerProxyProtocol{
+				TrustedSources: []string{"10.0.0.0/8"},
+				HeaderTimeout:  5 * time.Second,
+			},
+			Shutdown: ListenerShutdown{
+				PreStopDelay: 5 * time.Second,
+				DrainTimeout: 30 * time.Second,
+			},
 			Middlewares: []map[string]interface{}{
 				{
 					"name": "filter",
@@ -89,12 +119,42 @@ func (s *ConfigTestSuite) TestConfig() {
 					ResponseHTTPHeaders: map[string]string{
 						"Strict-Transport-Security": "max-age=31536000",
 					},
+					ResponseHeaders: []ServiceHeaderRule{
+						{Name: "Server", Action: "remove"},
+					},
 				},
 				Backend: ServiceBackend{
 					URL: "http://localhost:8082",
 					RequestHTTPHeaders: map[string]string{
 						"Host": "example.com",
 					},
+					RequestHeaders: []ServiceHeaderRule{
+						{Name: "X-Request-Id", Value: "$request_id"},
+					},
+				},
+				Routes: []ServiceRoute{
+					{
+						Path:  "/static",
+						Match: "prefix",
+						Backend: ServiceBackend{
+							URL: "http://localhost:8083",
+							RequestHTTPHeaders: map[string]string{
+								"Host": "static.example.com",
+							},
+						},
+					},
+				},
+				Maintenance: &ServiceMaintenance{
+					Windows: []ServiceMaintenanceWindow{
+						{Start: "2018-06-01T02:00:00Z", End: "2018-06-01T04:00:00Z"},
+					},
+					RetryAfter: 10 * time.Minute,
+					Bypass: ServiceMaintenanceBypass{
+						Networks: []string{"10.0.0.0/8"},
+						Header:   "X-Maintenance-Bypass",
+						Cookie:   "maintenance_bypass",
+						Secret:   "changeme",
+					},
 				},
 				Authentication: ServiceAuthentication{
 					Method: "BasicAuth",
@@ -115,6 +175,17 @@ func (s *ConfigTestSuite) TestConfig() {
 	s.Require().Equal(expCfg, cfg)
 }
 
+func (s *ConfigTestSuite) TestParseService() {
+	sd, err := ParseService([]byte(`{"frontend": {"fqdn": ["myservice.local"]}, "backend": {"url": "http://localhost:8082", "healthCheck": {"interval": "10s"}}}`))
+	s.Require().NoError(err)
+	s.Equal([]string{"myservice.local"}, sd.Frontend.FQDN)
+	s.Equal("http://localhost:8082", sd.Backend.URL)
+	s.Equal(10*time.Second, sd.Backend.HealthCheck.Interval)
+
+	_, err = ParseService([]byte(`{"frontend": {"unknown": true}}`))
+	s.Error(err)
+}
+
 func TestConfigTestSuite(t *testing.T) {
 	suite.Run(t, new(ConfigTestSuite))
 }
@@ -120,13 +120,12 @@ func (f *Filter) SetConfig(c types.MiddlewareConfig) error {
 func (f *Filter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userAgent := r.UserAgent()
-		addrString, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			log.Warnf("Error parsing remote addr: %s", err)
+		addr := net.ParseIP(types.ClientIP(r))
+		if addr == nil {
+			log.Warnf("Error parsing remote addr: %s", r.RemoteAddr)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		addr := net.ParseIP(addrString)
 
 		if f.isUserAgentDenied(userAgent) || f.isIPDenied(addr) || !f.isIPAllowed(addr) {
 			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
@@ -68,6 +68,12 @@ func (g *Gzip) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// gRPC messages are compressed by gRPC itself
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		w.Header().Set("Content-Encoding", "gzip")
 		w.Header().Del("Content-Length")
 
@@ -90,6 +90,22 @@ func (s *GZipMiddlewareTestSuite) TestAll() {
 	}
 }
 
+func (s *GZipMiddlewareTestSuite) TestGRPC() {
+	g := NewMiddleware()
+	s.Require().NoError(g.SetConfig(&GzipConfig{Level: 5}))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/pkg.Service/Method", nil)
+	s.Require().NoError(err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Content-Type", "application/grpc+proto")
+
+	g.Middleware(&testHandler{}).ServeHTTP(w, req)
+
+	s.Require().Equal("", w.Result().Header.Get("Content-Encoding"))
+	s.Require().Equal(handlerContent, w.Body.String())
+}
+
 func TestGZipMiddlewareTestSuite(t *testing.T) {
 	suite.Run(t, &GZipMiddlewareTestSuite{})
 }
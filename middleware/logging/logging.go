@@ -47,6 +47,7 @@ func (l *Logging) Middleware(next http.Handler) http.Handler {
 		log.WithFields(log.Fields{
 			"host":            strings.ToLower(r.Host),
 			"remote_addr":     remoteAddr,
+			"client_ip":       types.ClientIP(r),
 			"forwarded_for":   r.Header.Get("X-Forwarded-For"),
 			"forwarded_proto": r.Header.Get("X-Forwarded-Proto"),
 			"forwarded_host":  r.Header.Get("X-Forwarded-Host"),
@@ -79,6 +80,13 @@ func (rw *ResponseWriterWithStatus) Hijack() (net.Conn, *bufio.ReadWriter, error
 	return nil, nil, fmt.Errorf("Hijacker is not implemented in underlying ResponseWriter")
 }
 
+// Flush implements http.Flusher
+func (rw *ResponseWriterWithStatus) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // WriteHeader reimplements WriteHeader() to fill status automatically
 func (rw *ResponseWriterWithStatus) WriteHeader(status int) {
 	rw.Status = status
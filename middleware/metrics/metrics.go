@@ -20,6 +20,8 @@ type Metrics struct {
 	writeHeaderDurationSeconds *prometheus.HistogramVec
 	responseSizeBytes          *prometheus.HistogramVec
 	requestSizeBytes           *prometheus.HistogramVec
+	retriesTotal               *prometheus.CounterVec
+	backendGroupRequestsTotal  *prometheus.CounterVec
 }
 
 // ResponseWriterWithStatus implements adding status code to ResponseWriter object
@@ -39,6 +41,13 @@ func (rw *ResponseWriterWithStatus) WriteHeader(status int) {
 	}
 }
 
+// Flush implements http.Flusher
+func (rw *ResponseWriterWithStatus) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (rw *ResponseWriterWithStatus) Write(b []byte) (int, error) {
 	n, err := rw.ResponseWriter.Write(b)
 	rw.Written += int64(n)
@@ -98,12 +107,30 @@ func NewMiddleware() types.Middleware {
 		[]string{"host", "code", "method"},
 	)
 
+	m.retriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_retries_total",
+			Help: "A counter for retries of upstream requests made by the wrapped handler.",
+		},
+		[]string{"host", "code", "method"},
+	)
+
+	m.backendGroupRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_backend_group_requests_total",
+			Help: "A counter for requests passed to backend groups by the wrapped handler.",
+		},
+		[]string{"host", "group", "code"},
+	)
+
 	prometheus.MustRegister(m.inFlightRequests)
 	prometheus.MustRegister(m.httpRequestsTotal)
 	prometheus.MustRegister(m.responseDurationSeconds)
 	prometheus.MustRegister(m.writeHeaderDurationSeconds)
 	prometheus.MustRegister(m.requestSizeBytes)
 	prometheus.MustRegister(m.responseSizeBytes)
+	prometheus.MustRegister(m.retriesTotal)
+	prometheus.MustRegister(m.backendGroupRequestsTotal)
 
 	return &m
 }
@@ -125,6 +152,12 @@ func (m *Metrics) Middleware(next http.Handler) http.Handler {
 			},
 		}
 
+		stats := types.RequestStatsFromContext(r.Context())
+		if stats == nil {
+			stats = &types.RequestStats{}
+			r = r.WithContext(types.WithRequestStats(r.Context(), stats))
+		}
+
 		m.inFlightRequests.Inc()
 		defer m.inFlightRequests.Dec()
 
@@ -136,6 +169,14 @@ func (m *Metrics) Middleware(next http.Handler) http.Handler {
 		m.httpRequestsTotal.WithLabelValues(hostName, statusCode, r.Method).Inc()
 		m.requestSizeBytes.WithLabelValues(hostName, statusCode, r.Method).Observe(float64(calculateRequestSize(r)))
 		m.responseSizeBytes.WithLabelValues(hostName, statusCode, r.Method).Observe(float64(rw.Written))
+
+		if retries := stats.Retries(); retries > 0 {
+			m.retriesTotal.WithLabelValues(hostName, statusCode, r.Method).Add(float64(retries))
+		}
+
+		if group := stats.BackendGroup(); group != "" {
+			m.backendGroupRequestsTotal.WithLabelValues(hostName, group, statusCode).Inc()
+		}
 	})
 }
 
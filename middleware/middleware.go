@@ -14,24 +14,30 @@ import (
 )
 
 type middlewareDefinition struct {
-	middleware types.Middleware
-	config     types.MiddlewareConfig
+	middleware func() types.Middleware
+	config     func() types.MiddlewareConfig
 }
 
+// metricsMiddleware is shared between chains since it registers
+// Prometheus collectors which could be registered only once
+var metricsMiddleware = metrics.NewMiddleware()
+
+// middlewaresMap holds constructors so each chain gets its own instances
+// and could be replaced at runtime without affecting the running one
 var middlewaresMap = map[string]middlewareDefinition{
 	"filter": middlewareDefinition{
-		middleware: filter.NewMiddleware(),
-		config:     &filter.Config{},
+		middleware: filter.NewMiddleware,
+		config:     func() types.MiddlewareConfig { return &filter.Config{} },
 	},
 	"gzip": middlewareDefinition{
-		middleware: gzip.NewMiddleware(),
-		config:     &gzip.GzipConfig{},
+		middleware: gzip.NewMiddleware,
+		config:     func() types.MiddlewareConfig { return &gzip.GzipConfig{} },
 	},
 	"logging": middlewareDefinition{
-		middleware: logging.NewMiddleware(),
+		middleware: logging.NewMiddleware,
 	},
 	"metrics": middlewareDefinition{
-		middleware: metrics.NewMiddleware(),
+		middleware: func() types.Middleware { return metricsMiddleware },
 	},
 }
 
@@ -51,18 +57,20 @@ func Chain(f http.Handler, ms ...map[string]interface{}) (http.Handler, error) {
 			"middleware": name,
 		}).Debugf("Middleware initialized")
 
+		mw := md.middleware()
 		if md.config != nil {
-			err := md.config.Unpack(m)
+			cfg := md.config()
+			err := cfg.Unpack(m)
 			if err != nil {
 				return nil, err
 			}
 
-			err = md.middleware.SetConfig(md.config)
+			err = mw.SetConfig(cfg)
 			if err != nil {
 				return nil, err
 			}
 		}
-		f = md.middleware.Middleware(f)
+		f = mw.Middleware(f)
 	}
 
 	return f, nil
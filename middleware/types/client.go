@@ -0,0 +1,51 @@
+package types
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+type clientKey struct{}
+
+// Client describes the client sent the request as seen through
+// the trusted proxies in front of svcproxy
+type Client struct {
+	// IP is the address of the client
+	IP string
+	// PeerIP is the address of the connection peer
+	PeerIP string
+	// Proto and Host are the scheme and host requested by the client
+	Proto string
+	Host  string
+	// ForwardedFor is the X-Forwarded-For chain received from trusted
+	// peer, Forwarded is the list of RFC 7239 Forwarded elements
+	ForwardedFor []string
+	Forwarded    []string
+}
+
+// WithClient returns context carrying Client
+func WithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFromContext returns Client from context if any
+func ClientFromContext(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey{}).(*Client)
+	return c
+}
+
+// ClientIP returns address of the client sent the request, peer address
+// is returned if client wasn't resolved. Empty string is returned
+// if address couldn't be determined.
+func ClientIP(r *http.Request) string {
+	if c := ClientFromContext(r.Context()); c != nil {
+		return c.IP
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return ip
+}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type requestStatsKey struct{}
+
+// RequestStats carries per-request statistics collected by the handlers
+// down the chain to be reported by middlewares
+type RequestStats struct {
+	retries int64
+
+	mutex        sync.Mutex
+	backendGroup string
+}
+
+// AddRetry increments amount of retries made while handling request
+func (rs *RequestStats) AddRetry() {
+	atomic.AddInt64(&rs.retries, 1)
+}
+
+// Retries returns amount of retries made while handling request
+func (rs *RequestStats) Retries() int64 {
+	return atomic.LoadInt64(&rs.retries)
+}
+
+// SetBackendGroup records the name of backend group request was passed to
+func (rs *RequestStats) SetBackendGroup(name string) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.backendGroup = name
+}
+
+// BackendGroup returns the name of backend group request was passed to
+func (rs *RequestStats) BackendGroup() string {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	return rs.backendGroup
+}
+
+// WithRequestStats returns context carrying RequestStats
+func WithRequestStats(ctx context.Context, rs *RequestStats) context.Context {
+	return context.WithValue(ctx, requestStatsKey{}, rs)
+}
+
+// RequestStatsFromContext returns RequestStats from context if any
+func RequestStatsFromContext(ctx context.Context) *RequestStats {
+	rs, _ := ctx.Value(requestStatsKey{}).(*RequestStats)
+	return rs
+}
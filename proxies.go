@@ -0,0 +1,676 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdlog "log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/teran/svcproxy/authentication/factory"
+	"github.com/teran/svcproxy/config"
+	"github.com/teran/svcproxy/service"
+)
+
+// buildProxies creates proxies for the services defined in configuration.
+// Services failed to initialize are skipped, the errors are returned
+// to let caller decide if partial configuration is acceptable.
+func buildProxies(cfg *config.Config, logWriter io.Writer) ([]*service.Proxy, []error) {
+	var proxies []*service.Proxy
+	var errs []error
+
+	for _, sd := range cfg.Services {
+		sp, serrs := buildServiceProxies(cfg.Listener.Backend, sd, logWriter)
+		proxies = append(proxies, sp...)
+		errs = append(errs, serrs...)
+	}
+
+	return proxies, errs
+}
+
+// buildServiceProxies creates proxies for each FQDN of the service
+func buildServiceProxies(lb config.ListenerBackend, sd config.Service, logWriter io.Writer) ([]*service.Proxy, []error) {
+	var proxies []*service.Proxy
+	var errs []error
+
+	name := serviceName(sd)
+	opts := backendOptions{
+		service:         name,
+		rewrite:         sd.Rewrite,
+		responseRewrite: sd.ResponseRewrite,
+	}
+
+	transport := newTransport(lb)
+
+	a, err := factory.NewAuthenticator(sd.Authentication.Method, sd.Authentication.Options)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+			"object": sd.Authentication.Method,
+			"parent": sd.Frontend.FQDN,
+		}).Warn("Error: unable to initialize auhenticator. Skipping.")
+		return nil, []error{err}
+	}
+
+	ep, err := loadErrorPages(sd.ErrorPages)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+			"parent": sd.Frontend.FQDN,
+		}).Warn("Error: unable to load error pages. Skipping.")
+		return nil, []error{err}
+	}
+
+	m, err := newMaintenance(sd.Maintenance)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+			"parent": sd.Frontend.FQDN,
+		}).Warn("Error: unable to initialize maintenance mode. Skipping.")
+		return nil, []error{err}
+	}
+
+	groups, err := newBackendGroups(sd.BackendGroups, opts, transport, logWriter)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+			"parent": sd.Frontend.FQDN,
+		}).Warn("Error: unable to initialize backend group. Skipping.")
+		return nil, []error{err}
+	}
+
+	// Backend is replaced by groups if they're defined
+	var b *service.Backend
+	if len(groups) > 0 {
+		b = groups[0].Backend
+	} else {
+		b, err = newBackend(sd.Backend, opts, transport)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": sd.Backend.URL,
+				"parent": sd.Frontend.FQDN,
+			}).Warn("Error: unable to initialize backend. Skipping.")
+			return nil, []error{err}
+		}
+	}
+
+	var mb *service.Backend
+	if sd.Mirror != nil {
+		mb, err = newBackend(sd.Mirror.Backend, opts, transport)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": sd.Mirror.Backend.URL,
+				"parent": sd.Frontend.FQDN,
+			}).Warn("Error: unable to initialize mirror backend. Skipping.")
+			errs = append(errs, err)
+		}
+	}
+
+	var overrides []service.GroupOverride
+	for _, od := range sd.GroupOverrides {
+		overrides = append(overrides, service.GroupOverride{
+			Header: od.Header,
+			Cookie: od.Cookie,
+			Query:  od.Query,
+			Value:  od.Value,
+			Group:  od.Group,
+		})
+	}
+
+	var routes []*service.Route
+	for _, rd := range sd.Routes {
+		// Route rewrite rules replace the service ones
+		ropts := opts
+		if rd.Rewrite != nil {
+			ropts.rewrite = rd.Rewrite
+		}
+
+		rb, err := newBackend(rd.Backend, ropts, transport)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": rd.Backend.URL,
+				"parent": sd.Frontend.FQDN,
+			}).Warn("Error: unable to initialize route backend. Skipping.")
+			errs = append(errs, err)
+			continue
+		}
+
+		rt, err := service.NewRoute(rd.Match, rd.Path, rb, transport, stdlog.New(logWriter, "", 0))
+		if err != nil {
+			rb.Close()
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": rd.Path,
+				"parent": sd.Frontend.FQDN,
+			}).Warn("Error: unable to initialize route. Skipping.")
+			errs = append(errs, err)
+			continue
+		}
+
+		routes = append(routes, rt)
+	}
+
+	created := false
+	for _, fqdn := range sd.Frontend.FQDN {
+		_, err := service.NormalizeHost(fqdn)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": fqdn,
+			}).Warn("Error: invalid FQDN. Skipping.")
+			errs = append(errs, err)
+			continue
+		}
+
+		f, err := service.NewFrontend(fqdn, sd.Frontend.HTTPHandler, sd.Frontend.ResponseHTTPHeaders)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": fqdn,
+			}).Warn("Error: unable to initialize frontend. Skipping.")
+			errs = append(errs, err)
+			continue
+		}
+
+		err = f.SetResponseHeaders(headerRules(sd.Frontend.ResponseHeaders))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": fqdn,
+			}).Warn("Error: invalid response headers. Skipping.")
+			errs = append(errs, err)
+			continue
+		}
+
+		p, err := service.NewProxy(f, b, a, transport, stdlog.New(logWriter, "", 0))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": fqdn,
+			}).Warn("Error: unable to register proxy. Skipping.")
+			errs = append(errs, err)
+			continue
+		}
+
+		p.Service = name
+		if ep != nil {
+			if err := p.SetErrorPages(ep); err != nil {
+				log.WithFields(log.Fields{
+					"reason": err,
+					"object": fqdn,
+				}).Warn("Error: invalid error pages. Skipping.")
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		if m != nil {
+			if err := p.SetMaintenance(m); err != nil {
+				log.WithFields(log.Fields{
+					"reason": err,
+					"object": fqdn,
+				}).Warn("Error: invalid maintenance mode settings. Skipping.")
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		for _, rt := range routes {
+			p.AddRoute(rt)
+		}
+
+		if err := p.SetBackendGroups(groups, overrides); err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": fqdn,
+			}).Warn("Error: unable to set backend groups. Skipping.")
+			errs = append(errs, err)
+			continue
+		}
+
+		if mb != nil {
+			err := p.SetMirror(&service.Mirror{
+				Backend:     mb,
+				Percentage:  sd.Mirror.Percentage,
+				MaxBodySize: sd.Mirror.MaxBodySize,
+			}, transport)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"reason": err,
+					"object": fqdn,
+				}).Warn("Error: unable to set mirror. Skipping.")
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		proxies = append(proxies, p)
+		created = true
+	}
+
+	// Stop background activities of backends nobody uses
+	if !created {
+		b.Close()
+		for _, rt := range routes {
+			rt.Backend.Close()
+		}
+		for _, g := range groups {
+			g.Backend.Close()
+		}
+		if mb != nil {
+			mb.Close()
+		}
+	}
+
+	return proxies, errs
+}
+
+// newTransport creates transport passing requests to backends
+func newTransport(lb config.ListenerBackend) *http.Transport {
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   lb.Timeout,
+			KeepAlive: lb.KeepAlive,
+			DualStack: lb.DualStack,
+		}).DialContext,
+		ExpectContinueTimeout: lb.ExpectContinueTimeout,
+		IdleConnTimeout:       lb.IdleConnTimeout,
+		MaxIdleConns:          lb.MaxIdleConns,
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: lb.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   lb.TLSHandshakeTimeout,
+	}
+}
+
+// buildDefaultService creates the service handling requests for unknown
+// hosts, nil is returned if it isn't configured
+func buildDefaultService(lc config.Listener, logWriter io.Writer) (*service.DefaultService, error) {
+	dsd := lc.DefaultService
+	if dsd == nil {
+		return nil, nil
+	}
+
+	ds := &service.DefaultService{
+		Action:       dsd.Action,
+		RedirectURL:  dsd.RedirectURL,
+		PreservePath: dsd.PreservePath,
+		StatusCode:   dsd.StatusCode,
+		ContentType:  dsd.ContentType,
+	}
+
+	if dsd.File != "" {
+		body, err := ioutil.ReadFile(dsd.File)
+		if err != nil {
+			return nil, err
+		}
+		ds.Body = body
+	}
+
+	if dsd.Backend != nil {
+		transport := newTransport(lc.Backend)
+		b, err := newBackend(*dsd.Backend, backendOptions{service: "default"}, transport)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := service.NewProxy(&service.Frontend{}, b, nil, transport, stdlog.New(logWriter, "", 0))
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		p.Service = "default"
+		ds.Proxy = p
+	}
+
+	if err := ds.Validate(); err != nil {
+		if ds.Proxy != nil {
+			service.CloseProxies([]*service.Proxy{ds.Proxy})
+		}
+		return nil, err
+	}
+	return ds, nil
+}
+
+// loadFallbackCertificate loads certificate used for unknown hosts,
+// nil is returned if it isn't configured
+func loadFallbackCertificate(cd *config.ListenerFallbackCertificate) (*tls.Certificate, error) {
+	if cd == nil {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cd.CertFile, cd.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// newBackendGroups creates backend groups, backends of already created
+// groups are closed on error
+func newBackendGroups(gds []config.ServiceBackendGroup, opts backendOptions, transport http.RoundTripper, logWriter io.Writer) ([]*service.BackendGroup, error) {
+	var groups []*service.BackendGroup
+	closeGroups := func() {
+		for _, g := range groups {
+			g.Backend.Close()
+		}
+	}
+
+	for _, gd := range gds {
+		gb, err := newBackend(gd.Backend, opts, transport)
+		if err != nil {
+			closeGroups()
+			return nil, fmt.Errorf("group `%s`: %s", gd.Name, err)
+		}
+
+		g, err := service.NewBackendGroup(gd.Name, gd.Weight, gb, transport, stdlog.New(logWriter, "", 0))
+		if err != nil {
+			gb.Close()
+			closeGroups()
+			return nil, fmt.Errorf("group `%s`: %s", gd.Name, err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// backendOptions are service settings applied to each of service backends
+type backendOptions struct {
+	service         string
+	rewrite         *config.ServiceRewrite
+	responseRewrite *config.ServiceResponseRewrite
+}
+
+func responseRewriteRules(rds []config.ServiceResponseRewriteRule) []service.ResponseRewriteRule {
+	var rules []service.ResponseRewriteRule
+	for _, rd := range rds {
+		rules = append(rules, service.ResponseRewriteRule{
+			From: rd.From,
+			To:   rd.To,
+		})
+	}
+	return rules
+}
+
+func headerRules(hds []config.ServiceHeaderRule) []service.HeaderRule {
+	var rules []service.HeaderRule
+	for _, hd := range hds {
+		rules = append(rules, service.HeaderRule{
+			Name:   hd.Name,
+			Value:  hd.Value,
+			Action: hd.Action,
+		})
+	}
+	return rules
+}
+
+// loadErrorPages reads error page templates, nil is returned
+// if error pages aren't configured
+func loadErrorPages(epd *config.ErrorPages) (*service.ErrorPages, error) {
+	if epd == nil {
+		return nil, nil
+	}
+
+	load := func(files map[string]string) (map[int]string, error) {
+		pages := make(map[int]string)
+		for key, path := range files {
+			status := 0
+			if key != "default" {
+				var err error
+				status, err = strconv.Atoi(key)
+				if err != nil {
+					return nil, fmt.Errorf("invalid error page status: `%s`", key)
+				}
+			}
+
+			page, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			pages[status] = string(page)
+		}
+		return pages, nil
+	}
+
+	html, err := load(epd.HTML)
+	if err != nil {
+		return nil, err
+	}
+	json, err := load(epd.JSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.ErrorPages{
+		HTML:              html,
+		JSON:              json,
+		InterceptUpstream: epd.InterceptUpstream,
+	}, nil
+}
+
+// newMaintenance creates maintenance mode settings, nil is returned
+// if they aren't configured
+func newMaintenance(md *config.ServiceMaintenance) (*service.Maintenance, error) {
+	if md == nil {
+		return nil, nil
+	}
+
+	m := &service.Maintenance{
+		Enabled:        md.Enabled,
+		RetryAfter:     md.RetryAfter,
+		ContentType:    md.ContentType,
+		BypassNetworks: md.Bypass.Networks,
+		BypassHeader:   md.Bypass.Header,
+		BypassCookie:   md.Bypass.Cookie,
+		BypassSecret:   md.Bypass.Secret,
+	}
+
+	for _, wd := range md.Windows {
+		start, err := time.Parse(time.RFC3339, wd.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(time.RFC3339, wd.End)
+		if err != nil {
+			return nil, err
+		}
+		m.Windows = append(m.Windows, service.MaintenanceWindow{Start: start, End: end})
+	}
+
+	if md.Page != "" {
+		page, err := ioutil.ReadFile(md.Page)
+		if err != nil {
+			return nil, err
+		}
+		m.Page = page
+	}
+	return m, nil
+}
+
+// serviceName returns service name defaulting to its first FQDN
+func serviceName(sd config.Service) string {
+	if sd.Name != "" {
+		return sd.Name
+	}
+	if len(sd.Frontend.FQDN) > 0 {
+		return sd.Frontend.FQDN[0]
+	}
+	return ""
+}
+
+func newBackend(bd config.ServiceBackend, opts backendOptions, transport http.RoundTripper) (*service.Backend, error) {
+	var targets []*service.Target
+	if bd.URL != "" {
+		t, err := service.NewTarget(bd.URL, 1)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	for _, td := range bd.Targets {
+		t, err := service.NewTarget(td.URL, td.Weight)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	balancer, err := service.NewBalancer(bd.Balancer.Method, bd.Balancer.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := service.NewBalancedBackend(targets, balancer, bd.RequestHTTPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	b.SetService(opts.service)
+
+	if err := b.SetRequestHeaders(headerRules(bd.RequestHeaders)); err != nil {
+		return nil, err
+	}
+
+	if st := bd.Static; st != nil {
+		err = b.SetStatic(&service.StaticOptions{
+			IndexFiles:       st.IndexFiles,
+			SPAFallback:      st.SPAFallback,
+			DirectoryListing: st.DirectoryListing,
+			Precompressed:    st.Precompressed,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// PROXY protocol must be set up before health checks to be used by them
+	if bd.ProxyProtocol != 0 {
+		t, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("PROXY protocol is not supported by backend transport")
+		}
+		if err := b.SetProxyProtocol(bd.ProxyProtocol, t); err != nil {
+			return nil, err
+		}
+	}
+
+	// Protocol is set up before TLS settings to negotiate it
+	if bd.Protocol != "" {
+		t, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("backend protocol is not supported by backend transport")
+		}
+		if err := b.SetProtocol(bd.Protocol, t); err != nil {
+			return nil, err
+		}
+	}
+
+	// TLS settings are set up before health checks too
+	if td := bd.TLS; td != nil {
+		t, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("TLS settings are not supported by backend transport")
+		}
+		err = b.SetTLS(&service.BackendTLS{
+			CAFile:             td.CAFile,
+			CertFile:           td.CertFile,
+			KeyFile:            td.KeyFile,
+			ServerName:         td.ServerName,
+			MinVersion:         td.MinVersion,
+			Pins:               td.Pins,
+			InsecureSkipVerify: td.InsecureSkipVerify,
+		}, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bd.CircuitBreaker != nil {
+		err = b.SetCircuitBreaker(&service.CircuitBreaker{
+			ConsecutiveFailures: bd.CircuitBreaker.ConsecutiveFailures,
+			CoolDown:            bd.CircuitBreaker.CoolDown,
+			HalfOpenRequests:    bd.CircuitBreaker.HalfOpenRequests,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bd.RetryPolicy != nil {
+		err = b.SetRetryPolicy(&service.RetryPolicy{
+			MaxAttempts:   bd.RetryPolicy.MaxAttempts,
+			RetryOn:       bd.RetryPolicy.RetryOn,
+			StatusCodes:   bd.RetryPolicy.StatusCodes,
+			PerTryTimeout: bd.RetryPolicy.PerTryTimeout,
+			Budget:        bd.RetryPolicy.Budget,
+			MaxBodySize:   bd.RetryPolicy.MaxBodySize,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rw := opts.rewrite; rw != nil {
+		err = b.SetRewrite(&service.Rewrite{
+			StripPrefix: rw.StripPrefix,
+			AddPrefix:   rw.AddPrefix,
+			Regex:       rw.Regex,
+			Replacement: rw.Replacement,
+			AddQuery:    rw.AddQuery,
+			RemoveQuery: rw.RemoveQuery,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rr := opts.responseRewrite; rr != nil {
+		err = b.SetResponseRewrite(&service.ResponseRewrite{
+			TargetRedirects: rr.TargetRedirects,
+			Redirects:       responseRewriteRules(rr.Redirects),
+			CookieDomains:   responseRewriteRules(rr.CookieDomains),
+			CookiePaths:     responseRewriteRules(rr.CookiePaths),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bd.Affinity != nil {
+		err = b.SetAffinity(&service.Affinity{
+			Cookie:   bd.Affinity.Cookie,
+			TTL:      bd.Affinity.TTL,
+			Secure:   bd.Affinity.Secure,
+			SameSite: bd.Affinity.SameSite,
+			Secret:   bd.Affinity.Secret,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bd.HealthCheck != nil {
+		err = b.SetHealthCheck(&service.HealthCheck{
+			Type:               bd.HealthCheck.Type,
+			Path:               bd.HealthCheck.Path,
+			Interval:           bd.HealthCheck.Interval,
+			Timeout:            bd.HealthCheck.Timeout,
+			HealthyThreshold:   bd.HealthCheck.HealthyThreshold,
+			UnhealthyThreshold: bd.HealthCheck.UnhealthyThreshold,
+			ExpectedStatus:     bd.HealthCheck.ExpectedStatus,
+		}, transport)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
@@ -0,0 +1,232 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v1MaxLength is the maximum length of v1 header including CRLF
+const v1MaxLength = 107
+
+// v2Signature starts v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	// ErrNoHeader is returned when connection doesn't start with PROXY
+	// protocol header
+	ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+	// ErrInvalidHeader is returned when PROXY protocol header is malformed
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+)
+
+// Header is PROXY protocol header. Source and destination addresses
+// are nil for LOCAL command and for unknown protocols.
+type Header struct {
+	Version     int
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+}
+
+// ReadHeader reads PROXY protocol v1 or v2 header from the reader.
+// ErrNoHeader is returned without consuming any data if reader
+// doesn't start with the header.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b[0] {
+	case 'P':
+		b, err = r.Peek(6)
+		if err != nil || string(b) != "PROXY " {
+			return nil, noHeader(err)
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		b, err = r.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(b, v2Signature) {
+			return nil, noHeader(err)
+		}
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// noHeader returns the error of peeking data if any
+func noHeader(err error) error {
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return ErrNoHeader
+}
+
+// readV1 reads header like `PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n`
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 {
+		return nil, ErrInvalidHeader
+	}
+
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	h.Source = src
+	h.Destination = dst
+	return h, nil
+}
+
+func parseV1Addr(proto, addr, port string) (*net.TCPAddr, error) {
+	// IPv6 addresses are written with colons even for IPv4-mapped ones
+	ip := net.ParseIP(addr)
+	isV6 := strings.Contains(addr, ":")
+	if ip == nil || !(proto == "TCP4" && !isV6 || proto == "TCP6" && isV6) {
+		return nil, ErrInvalidHeader
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads binary header
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, len(v2Signature)+4)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	verCmd, family := fixed[12], fixed[13]
+	if verCmd>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	switch verCmd & 0x0F {
+	case 0x00:
+		// LOCAL command, e.g. health check of the proxy itself
+		return h, nil
+	case 0x01:
+	default:
+		return nil, ErrInvalidHeader
+	}
+
+	var ipLen int
+	switch family {
+	case 0x11:
+		ipLen = net.IPv4len
+	case 0x21:
+		ipLen = net.IPv6len
+	default:
+		// Only TCP over IPv4 and IPv6 is supported, addresses of other
+		// protocols are ignored
+		return h, nil
+	}
+
+	if len(payload) < 2*ipLen+4 {
+		return nil, ErrInvalidHeader
+	}
+	h.Source = &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	h.Destination = &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return h, nil
+}
+
+// String returns the text form of the header
+func (h *Header) String() string {
+	if h.Source == nil || h.Destination == nil {
+		return fmt.Sprintf("PROXY v%d UNKNOWN", h.Version)
+	}
+	return fmt.Sprintf("PROXY v%d %s -> %s", h.Version, h.Source, h.Destination)
+}
+
+// Format returns the header in the wire format of its version. Header
+// without addresses is formatted as UNKNOWN for v1 and LOCAL for v2.
+func (h *Header) Format() []byte {
+	src, dst := h.Source, h.Destination
+	hasAddrs := src != nil && dst != nil
+
+	// Both addresses must be of the same family, IPv4 addresses
+	// are mapped to IPv6 if families differ
+	v4 := hasAddrs && src.IP.To4() != nil && dst.IP.To4() != nil
+
+	if h.Version == 1 {
+		if !hasAddrs {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, formatV1IP(src.IP, v4), formatV1IP(dst.IP, v4), src.Port, dst.Port))
+	}
+
+	b := append([]byte{}, v2Signature...)
+	if !hasAddrs {
+		return append(b, 0x20, 0x00, 0x00, 0x00)
+	}
+
+	family, srcIP, dstIP := byte(0x21), src.IP.To16(), dst.IP.To16()
+	if v4 {
+		family, srcIP, dstIP = 0x11, src.IP.To4(), dst.IP.To4()
+	}
+
+	b = append(b, 0x21, family, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(2*len(srcIP)+4))
+	b = append(b, srcIP...)
+	b = append(b, dstIP...)
+	b = append(b, byte(src.Port>>8), byte(src.Port), byte(dst.Port>>8), byte(dst.Port))
+	return b
+}
+
+// formatV1IP formats IPv4 address in IPv4-mapped IPv6 form if needed
+func formatV1IP(ip net.IP, v4 bool) string {
+	if !v4 && ip.To4() != nil {
+		return "::ffff:" + ip.String()
+	}
+	return ip.String()
+}
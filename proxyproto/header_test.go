@@ -0,0 +1,166 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HeaderTestSuite struct {
+	suite.Suite
+}
+
+func v2Header(cmd, family byte, addrs []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+func (s *HeaderTestSuite) TestReadHeader() {
+	v4 := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xDC, 0x04, 0x01, 0xBB}
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xDC, 0x04, 0x01, 0xBB)
+
+	tcs := []struct {
+		name   string
+		data   []byte
+		err    error
+		src    string
+		dst    string
+		noAddr bool
+	}{
+		{
+			name: "v1 TCP4",
+			data: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"),
+			src:  "192.0.2.1:56324",
+			dst:  "192.0.2.2:443",
+		},
+		{
+			name: "v1 TCP6",
+			data: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			src:  "[2001:db8::1]:56324",
+			dst:  "[2001:db8::2]:443",
+		},
+		{
+			name:   "v1 UNKNOWN",
+			data:   []byte("PROXY UNKNOWN\r\n"),
+			noAddr: true,
+		},
+		{
+			name: "v1 address family mismatch",
+			data: []byte("PROXY TCP4 2001:db8::1 192.0.2.2 56324 443\r\n"),
+			err:  ErrInvalidHeader,
+		},
+		{
+			name: "v1 without CRLF",
+			data: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443" + strings.Repeat(" ", 100)),
+			err:  ErrInvalidHeader,
+		},
+		{
+			name: "v2 TCP4",
+			data: v2Header(0x01, 0x11, v4),
+			src:  "192.0.2.1:56324",
+			dst:  "192.0.2.2:443",
+		},
+		{
+			name: "v2 TCP6 with TLVs",
+			data: v2Header(0x01, 0x21, append(v6, 0x04, 0x00, 0x01, 0x00)),
+			src:  "[2001:db8::1]:56324",
+			dst:  "[2001:db8::2]:443",
+		},
+		{
+			name:   "v2 LOCAL",
+			data:   v2Header(0x00, 0x00, nil),
+			noAddr: true,
+		},
+		{
+			name: "v2 truncated addresses",
+			data: v2Header(0x01, 0x11, v4[:8]),
+			err:  ErrInvalidHeader,
+		},
+		{
+			name: "no header",
+			data: []byte("GET / HTTP/1.1\r\n"),
+			err:  ErrNoHeader,
+		},
+	}
+
+	for _, tc := range tcs {
+		r := bufio.NewReader(bytes.NewReader(append(tc.data, []byte("payload")...)))
+		h, err := ReadHeader(r)
+		if tc.err != nil {
+			s.Equal(tc.err, err, tc.name)
+			continue
+		}
+		s.Require().NoError(err, tc.name)
+
+		if tc.noAddr {
+			s.Nil(h.Source, tc.name)
+			s.Nil(h.Destination, tc.name)
+		} else {
+			s.Equal(tc.src, h.Source.String(), tc.name)
+			s.Equal(tc.dst, h.Destination.String(), tc.name)
+		}
+
+		rest, err := ioutil.ReadAll(r)
+		s.Require().NoError(err, tc.name)
+		s.Equal("payload", string(rest), tc.name)
+	}
+}
+
+func (s *HeaderTestSuite) TestFormat() {
+	v4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+
+	tcs := []struct {
+		name string
+		src  *net.TCPAddr
+		dst  *net.TCPAddr
+	}{
+		{
+			name: "IPv4",
+			src:  v4,
+			dst:  &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443},
+		},
+		{
+			name: "mixed families",
+			src:  v4,
+			dst:  v6,
+		},
+		{
+			name: "no addresses",
+		},
+	}
+
+	for _, tc := range tcs {
+		for _, version := range []int{1, 2} {
+			h := &Header{Version: version, Source: tc.src, Destination: tc.dst}
+
+			parsed, err := ReadHeader(bufio.NewReader(bytes.NewReader(h.Format())))
+			s.Require().NoError(err, tc.name)
+			s.Equal(version, parsed.Version, tc.name)
+
+			if tc.src == nil {
+				s.Nil(parsed.Source, tc.name)
+				continue
+			}
+			s.True(tc.src.IP.Equal(parsed.Source.IP), tc.name)
+			s.Equal(tc.src.Port, parsed.Source.Port, tc.name)
+			s.True(tc.dst.IP.Equal(parsed.Destination.IP), tc.name)
+			s.Equal(tc.dst.Port, parsed.Destination.Port, tc.name)
+		}
+	}
+
+	h := &Header{Version: 1, Source: v4, Destination: v6}
+	s.Equal("PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 56324 443\r\n", string(h.Format()))
+}
+
+func TestHeaderTestSuite(t *testing.T) {
+	suite.Run(t, new(HeaderTestSuite))
+}
@@ -0,0 +1,139 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHeaderTimeout is the default time allowed to read the header
+const DefaultHeaderTimeout = 5 * time.Second
+
+// ErrUntrustedSource is returned for connections required to pass
+// the header from sources not allowed to pass it
+var ErrUntrustedSource = errors.New("proxyproto: connection source is not trusted")
+
+// Listener accepts connections passing PROXY protocol header. Header is
+// read on the first Read, RemoteAddr or LocalAddr call so slow clients
+// don't block accepting new connections.
+type Listener struct {
+	net.Listener
+	// Required rejects connections without the header
+	Required bool
+	// TrustedSources are networks allowed to pass the header, connections
+	// from other sources are handled as plain ones or rejected if the
+	// header is required. No source is trusted if the list is empty, any
+	// client would be able to spoof its address otherwise.
+	TrustedSources []*net.IPNet
+	// HeaderTimeout limits time to read the header
+	HeaderTimeout time.Duration
+}
+
+// Accept waits for the next connection and wraps it to read the header
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		Conn:     c,
+		listener: l,
+		reader:   bufio.NewReader(c),
+	}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.TrustedSources {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is the connection with addresses taken from PROXY protocol header
+type Conn struct {
+	net.Conn
+	listener *Listener
+	reader   *bufio.Reader
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// Header returns PROXY protocol header passed by the connection source,
+// nil is returned if the header wasn't passed
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *Conn) readHeader() {
+	if !c.listener.isTrusted(c.Conn.RemoteAddr()) {
+		if c.listener.Required {
+			c.fail(ErrUntrustedSource)
+		}
+		return
+	}
+
+	timeout := c.listener.HeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.header, c.err = ReadHeader(c.reader)
+	if c.err == ErrNoHeader && !c.listener.Required {
+		c.err = nil
+	}
+	if c.err != nil {
+		c.fail(c.err)
+	}
+}
+
+// fail closes the connection so nothing is sent to the rejected source
+func (c *Conn) fail(err error) {
+	c.err = err
+	c.header = nil
+	c.Conn.Close()
+
+	log.WithFields(log.Fields{
+		"reason": err,
+		"remote": c.Conn.RemoteAddr().String(),
+	}).Debug("Error reading PROXY protocol header. Connection closed.")
+}
+
+// Read reads data following the header
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns source address passed in the header if any
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns destination address passed in the header if any
+func (c *Conn) LocalAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,116 @@
+package proxyproto
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ListenerTestSuite struct {
+	suite.Suite
+}
+
+func (s *ListenerTestSuite) serve(l *Listener) string {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	l.Listener = tcpListener
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	})}
+	go srv.Serve(l)
+	return tcpListener.Addr().String()
+}
+
+func (s *ListenerTestSuite) request(addr, header string) (string, error) {
+	c, err := net.Dial("tcp", addr)
+	s.Require().NoError(err)
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c, "%sGET / HTTP/1.1\r\nHost: test.local\r\nConnection: close\r\n\r\n", header)
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// loopback returns trusted sources of the test clients
+func (s *ListenerTestSuite) loopback() []*net.IPNet {
+	_, n, err := net.ParseCIDR("127.0.0.0/8")
+	s.Require().NoError(err)
+	return []*net.IPNet{n}
+}
+
+func (s *ListenerTestSuite) TestOptional() {
+	addr := s.serve(&Listener{TrustedSources: s.loopback()})
+
+	remote, err := s.request(addr, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+	s.Require().NoError(err)
+	s.Equal("192.0.2.1:56324", remote)
+
+	remote, err = s.request(addr, "")
+	s.Require().NoError(err)
+	host, _, err := net.SplitHostPort(remote)
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1", host)
+}
+
+func (s *ListenerTestSuite) TestRequired() {
+	addr := s.serve(&Listener{Required: true, TrustedSources: s.loopback(), HeaderTimeout: time.Second})
+
+	remote, err := s.request(addr, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+	s.Require().NoError(err)
+	s.Equal("192.0.2.1:56324", remote)
+
+	_, err = s.request(addr, "")
+	s.Error(err)
+}
+
+func (s *ListenerTestSuite) TestUntrustedSource() {
+	_, n, err := net.ParseCIDR("192.0.2.0/24")
+	s.Require().NoError(err)
+
+	addr := s.serve(&Listener{TrustedSources: []*net.IPNet{n}})
+
+	// Header from untrusted source is not parsed
+	remote, err := s.request(addr, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+	s.Require().NoError(err)
+	s.Contains(remote, "400 Bad Request")
+
+	remote, err = s.request(addr, "")
+	s.Require().NoError(err)
+	s.NotEqual("192.0.2.1:56324", remote)
+
+	addr = s.serve(&Listener{Required: true, TrustedSources: []*net.IPNet{n}})
+	_, err = s.request(addr, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+	s.Error(err)
+}
+
+func (s *ListenerTestSuite) TestNoTrustedSources() {
+	addr := s.serve(&Listener{})
+
+	// Header is not trusted without trusted sources listed
+	remote, err := s.request(addr, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+	s.Require().NoError(err)
+	s.Contains(remote, "400 Bad Request")
+
+	addr = s.serve(&Listener{Required: true})
+	_, err = s.request(addr, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+	s.Error(err)
+}
+
+func TestListenerTestSuite(t *testing.T) {
+	suite.Run(t, new(ListenerTestSuite))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/teran/svcproxy/config"
+	"github.com/teran/svcproxy/service"
+)
+
+// reloadableHandler allows to replace handler of running http.Server
+type reloadableHandler struct {
+	handler atomic.Value
+}
+
+type handlerHolder struct {
+	http.Handler
+}
+
+func newReloadableHandler(h http.Handler) *reloadableHandler {
+	rh := &reloadableHandler{}
+	rh.Set(h)
+	return rh
+}
+
+// Set replaces handler
+func (rh *reloadableHandler) Set(h http.Handler) {
+	rh.handler.Store(handlerHolder{h})
+}
+
+func (rh *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.handler.Load().(handlerHolder).ServeHTTP(w, r)
+}
+
+// reloader applies configuration changes to running service
+type reloader struct {
+	mutex        sync.Mutex
+	configPath   string
+	cfg          *config.Config
+	svc          *service.Svc
+	acm          *autocert.Manager
+	logWriter    io.Writer
+	httpHandler  *reloadableHandler
+	httpsHandler *reloadableHandler
+}
+
+// Reload reads configuration file, builds new proxies and middlewares
+// and replaces the running ones. Running configuration is kept untouched
+// if the new one is invalid.
+func (rl *reloader) Reload() error {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	log.Info("Reloading configuration")
+
+	cfg, err := config.Load(rl.configPath)
+	if err != nil {
+		return rl.fail(err)
+	}
+
+	proxies, errs := buildProxies(cfg, rl.logWriter)
+	if len(errs) > 0 {
+		service.CloseProxies(proxies)
+		return rl.fail(fmt.Errorf("%d error(s) in services definitions, the first one: %s", len(errs), errs[0]))
+	}
+
+	httpHandler, err := newHandlerChain(cfg.Listener, rl.svc, rl.acm.HTTPHandler(rl.svc))
+	if err != nil {
+		service.CloseProxies(proxies)
+		return rl.fail(err)
+	}
+
+	httpsHandler, err := newHandlerChain(cfg.Listener, rl.svc, rl.svc)
+	if err != nil {
+		service.CloseProxies(proxies)
+		return rl.fail(err)
+	}
+
+	ep, err := loadErrorPages(cfg.Listener.ErrorPages)
+	if err != nil {
+		service.CloseProxies(proxies)
+		return rl.fail(err)
+	}
+	if err := ep.Validate(); err != nil {
+		service.CloseProxies(proxies)
+		return rl.fail(err)
+	}
+
+	fallbackCert, err := loadFallbackCertificate(cfg.Listener.FallbackCertificate)
+	if err != nil {
+		service.CloseProxies(proxies)
+		return rl.fail(err)
+	}
+
+	ds, err := buildDefaultService(cfg.Listener, rl.logWriter)
+	if err != nil {
+		service.CloseProxies(proxies)
+		return rl.fail(err)
+	}
+
+	err = rl.svc.SetProxies(proxies)
+	if err != nil {
+		service.CloseProxies(proxies)
+		if ds != nil && ds.Proxy != nil {
+			service.CloseProxies([]*service.Proxy{ds.Proxy})
+		}
+		return rl.fail(err)
+	}
+	rl.svc.SetErrorPages(ep)
+	rl.svc.SetDefaultService(ds)
+	rl.svc.SetAdminToken(cfg.Listener.Admin.Token)
+	rl.svc.SetFallbackCertificate(fallbackCert)
+	rl.httpHandler.Set(httpHandler)
+	rl.httpsHandler.Set(httpsHandler)
+
+	logServicesDiff(rl.cfg.Services, cfg.Services)
+	logRestartRequired(rl.cfg, cfg)
+
+	rl.cfg = cfg
+
+	log.Info("Configuration reloaded")
+	return nil
+}
+
+// BuildService creates proxies for the service added via admin API
+// using backend settings of the running configuration
+func (rl *reloader) BuildService(name string, definition []byte) ([]*service.Proxy, error) {
+	sd, err := config.ParseService(definition)
+	if err != nil {
+		return nil, err
+	}
+	sd.Name = name
+
+	rl.mutex.Lock()
+	lb := rl.cfg.Listener.Backend
+	rl.mutex.Unlock()
+
+	proxies, errs := buildServiceProxies(lb, *sd, rl.logWriter)
+	if len(errs) > 0 {
+		service.CloseProxies(proxies)
+		return nil, errs[0]
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no FQDN defined for service `%s`", name)
+	}
+	return proxies, nil
+}
+
+// watchSignals reloads configuration on SIGHUP
+func (rl *reloader) watchSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			rl.Reload()
+		}
+	}()
+}
+
+func (rl *reloader) fail(err error) error {
+	log.WithFields(log.Fields{
+		"reason": err,
+	}).Warn("Error reloading configuration. Keeping the running one.")
+	return err
+}
+
+// logServicesDiff logs services added, removed or changed by FQDN
+func logServicesDiff(oldServices, newServices []config.Service) {
+	oldDefs := servicesByFQDN(oldServices)
+	newDefs := servicesByFQDN(newServices)
+
+	for fqdn, nsd := range newDefs {
+		osd, ok := oldDefs[fqdn]
+		if !ok {
+			log.WithFields(log.Fields{"fqdn": fqdn}).Info("Service added")
+			continue
+		}
+		if !reflect.DeepEqual(osd, nsd) {
+			log.WithFields(log.Fields{"fqdn": fqdn}).Info("Service changed")
+		}
+	}
+
+	for fqdn := range oldDefs {
+		if _, ok := newDefs[fqdn]; !ok {
+			log.WithFields(log.Fields{"fqdn": fqdn}).Info("Service removed")
+		}
+	}
+}
+
+func servicesByFQDN(services []config.Service) map[string]config.Service {
+	defs := make(map[string]config.Service)
+	for _, sd := range services {
+		for _, fqdn := range sd.Frontend.FQDN {
+			defs[fqdn] = sd
+		}
+	}
+	return defs
+}
+
+// logRestartRequired warns about changes could not be applied at runtime
+func logRestartRequired(oldCfg, newCfg *config.Config) {
+	// Backend settings and middlewares are applied with services
+	oldListener := oldCfg.Listener
+	oldListener.Backend = config.ListenerBackend{}
+	oldListener.Middlewares = nil
+	oldListener.Forwarding = config.ListenerForwarding{}
+	oldListener.ErrorPages = nil
+	oldListener.DefaultService = nil
+	oldListener.FallbackCertificate = nil
+	oldListener.Admin = config.ListenerAdmin{}
+	newListener := newCfg.Listener
+	newListener.Backend = config.ListenerBackend{}
+	newListener.Middlewares = nil
+	newListener.Forwarding = config.ListenerForwarding{}
+	newListener.ErrorPages = nil
+	newListener.DefaultService = nil
+	newListener.FallbackCertificate = nil
+	newListener.Admin = config.ListenerAdmin{}
+
+	if !reflect.DeepEqual(oldListener, newListener) ||
+		!reflect.DeepEqual(oldCfg.Logger, newCfg.Logger) ||
+		!reflect.DeepEqual(oldCfg.Autocert, newCfg.Autocert) {
+		log.Warn("Listener, logger and autocert settings changes require restart to be applied")
+	}
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// maxServiceDefinitionSize limits the size of service definitions
+// accepted by admin API
+const maxServiceDefinitionSize = 1 << 20
+
+type serviceView struct {
+	Name          string         `json:"name"`
+	Draining      bool           `json:"draining"`
+	Maintenance   bool           `json:"maintenance"`
+	Authenticator string         `json:"authenticator"`
+	Frontends     []frontendView `json:"frontends"`
+	Backend       backendView    `json:"backend"`
+	Routes        []routeView    `json:"routes,omitempty"`
+	Groups        []groupView    `json:"groups,omitempty"`
+}
+
+type frontendView struct {
+	FQDN                string            `json:"fqdn"`
+	HTTPHandler         string            `json:"httpHandler"`
+	ResponseHTTPHeaders map[string]string `json:"responseHTTPHeaders,omitempty"`
+}
+
+type backendView struct {
+	Targets []targetView `json:"targets"`
+}
+
+type targetView struct {
+	URL          string `json:"url"`
+	Weight       int    `json:"weight"`
+	Healthy      bool   `json:"healthy"`
+	CircuitState string `json:"circuitState"`
+	Outstanding  int64  `json:"outstanding"`
+}
+
+type routeView struct {
+	Match   RouteMatch  `json:"match"`
+	Path    string      `json:"path"`
+	Backend backendView `json:"backend"`
+}
+
+type groupView struct {
+	Name    string      `json:"name"`
+	Weight  int         `json:"weight"`
+	Backend backendView `json:"backend"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// SetAdminToken sets the token admin API requests changing services must
+// pass in `Authorization: Bearer <token>` header. Admin API is read-only
+// if the token is empty.
+func (s *Svc) SetAdminToken(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.adminToken = token
+}
+
+// adminAuthorized checks the request is allowed to change services
+func (s *Svc) adminAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return true
+	}
+
+	s.mutex.RLock()
+	token := s.adminToken
+	s.mutex.RUnlock()
+
+	if token == "" {
+		writeJSON(w, http.StatusForbidden, adminError{"admin API is read-only: admin token is not set"})
+		return false
+	}
+
+	passed := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(passed), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="svcproxy admin"`)
+		writeJSON(w, http.StatusUnauthorized, adminError{http.StatusText(http.StatusUnauthorized)})
+		return false
+	}
+	return true
+}
+
+// adminServices handles /admin/services: lists the services
+func (s *Svc) adminServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeJSON(w, http.StatusMethodNotAllowed, adminError{http.StatusText(http.StatusMethodNotAllowed)})
+		return
+	}
+
+	views := []serviceView{}
+	for _, name := range s.ServiceNames() {
+		if proxies, err := s.ServiceProxies(name); err == nil {
+			views = append(views, newServiceView(name, proxies))
+		}
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// adminService handles /admin/services/<name> and /admin/services/<name>/drain:
+//
+//	GET    /admin/services/<name>       shows the service
+//	PUT    /admin/services/<name>       adds or replaces the service by definition in request body
+//	DELETE /admin/services/<name>       removes the service
+//	PUT    /admin/services/<name>/drain enables drain mode
+//	DELETE /admin/services/<name>/drain disables drain mode
+//	PUT    /admin/services/<name>/maintenance enables maintenance mode
+//	DELETE /admin/services/<name>/maintenance disables maintenance mode
+//
+// Requests changing services must be authorized with admin token.
+func (s *Svc) adminService(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAuthorized(w, r) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/admin/services/")
+	if strings.HasSuffix(name, "/drain") {
+		s.adminDrain(w, r, strings.TrimSuffix(name, "/drain"))
+		return
+	}
+	if strings.HasSuffix(name, "/maintenance") {
+		s.adminMaintenance(w, r, strings.TrimSuffix(name, "/maintenance"))
+		return
+	}
+	if name == "" || strings.Contains(name, "/") {
+		writeJSON(w, http.StatusNotFound, adminError{ErrServiceNotFound.Error()})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		proxies, err := s.ServiceProxies(name)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, adminError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, newServiceView(name, proxies))
+	case "PUT":
+		s.adminPutService(w, r, name)
+	case "DELETE":
+		if err := s.RemoveService(name); err != nil {
+			writeJSON(w, http.StatusNotFound, adminError{err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, adminError{http.StatusText(http.StatusMethodNotAllowed)})
+	}
+}
+
+func (s *Svc) adminPutService(w http.ResponseWriter, r *http.Request, name string) {
+	if s.build == nil {
+		writeJSON(w, http.StatusNotImplemented, adminError{"adding services is not supported"})
+		return
+	}
+
+	definition, err := ioutil.ReadAll(io.LimitReader(r.Body, maxServiceDefinitionSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+
+	proxies, err := s.build(name, definition)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+
+	// Local files and sockets like Docker API one must not be published
+	// by anyone reaching debug listener, they're allowed in configuration only
+	if t := localTarget(proxies); t != nil {
+		CloseProxies(proxies)
+		writeJSON(w, http.StatusForbidden, adminError{"target is not allowed via admin API: " + t.URL.String()})
+		return
+	}
+
+	created, err := s.PutService(name, proxies)
+	if err != nil {
+		CloseProxies(proxies)
+		writeJSON(w, http.StatusConflict, adminError{err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, newServiceView(name, proxies))
+}
+
+func (s *Svc) adminDrain(w http.ResponseWriter, r *http.Request, name string) {
+	var draining bool
+	switch r.Method {
+	case "PUT":
+		draining = true
+	case "DELETE":
+		draining = false
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, adminError{http.StatusText(http.StatusMethodNotAllowed)})
+		return
+	}
+
+	if err := s.SetDraining(name, draining); err != nil {
+		writeJSON(w, http.StatusNotFound, adminError{err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Svc) adminMaintenance(w http.ResponseWriter, r *http.Request, name string) {
+	var enabled bool
+	switch r.Method {
+	case "PUT":
+		enabled = true
+	case "DELETE":
+		enabled = false
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, adminError{http.StatusText(http.StatusMethodNotAllowed)})
+		return
+	}
+
+	if err := s.SetMaintenanceMode(name, enabled); err != nil {
+		writeJSON(w, http.StatusNotFound, adminError{err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newServiceView(name string, proxies []*Proxy) serviceView {
+	// Proxies of the same service share backends, routes, groups and authenticator
+	p := proxies[0]
+
+	v := serviceView{
+		Name:        name,
+		Draining:    p.Draining(),
+		Maintenance: p.MaintenanceMode(),
+		Backend:     newBackendView(p.Backend),
+	}
+
+	if p.Authenticator != nil {
+		t := reflect.TypeOf(p.Authenticator)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		v.Authenticator = t.Name()
+	}
+
+	for _, p := range proxies {
+		v.Frontends = append(v.Frontends, frontendView{
+			FQDN:                p.Frontend.FQDN,
+			HTTPHandler:         p.Frontend.HTTPHandler,
+			ResponseHTTPHeaders: p.Frontend.ResponseHTTPHeaders,
+		})
+	}
+
+	for _, rt := range p.Routes {
+		v.Routes = append(v.Routes, routeView{
+			Match:   rt.Match,
+			Path:    rt.Path,
+			Backend: newBackendView(rt.Backend),
+		})
+	}
+
+	for _, g := range p.Groups {
+		v.Groups = append(v.Groups, groupView{
+			Name:    g.Name,
+			Weight:  g.Weight,
+			Backend: newBackendView(g.Backend),
+		})
+	}
+	return v
+}
+
+func newBackendView(b *Backend) backendView {
+	v := backendView{}
+	for _, t := range b.Targets {
+		v.Targets = append(v.Targets, targetView{
+			URL:          t.URL.String(),
+			Weight:       t.Weight,
+			Healthy:      t.Healthy(),
+			CircuitState: t.CircuitState().String(),
+			Outstanding:  t.Outstanding(),
+		})
+	}
+	return v
+}
+
+// localTarget returns the first `file://` or `unix://` target of
+// the proxies, mirror backends are checked as well
+func localTarget(proxies []*Proxy) *Target {
+	var backends []*Backend
+	for _, p := range proxies {
+		backends = append(backends, p.Backend)
+		for _, rt := range p.Routes {
+			backends = append(backends, rt.Backend)
+		}
+		for _, g := range p.Groups {
+			backends = append(backends, g.Backend)
+		}
+		if p.mirror != nil {
+			backends = append(backends, p.mirror.Backend)
+		}
+	}
+
+	for _, b := range backends {
+		if b == nil {
+			continue
+		}
+		for _, t := range b.Targets {
+			if t.static != nil || t.socket != "" {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/teran/svcproxy/authentication/noauth"
+)
+
+type AdminTestSuite struct {
+	suite.Suite
+
+	backend *httptest.Server
+	svc     *Svc
+}
+
+func (s *AdminTestSuite) SetupTest() {
+	s.backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+	s.svc = svc
+	s.svc.SetAdminToken("secret")
+
+	// Test builder treats definition as comma-separated FQDN list
+	s.svc.SetServiceBuilder(func(name string, definition []byte) ([]*Proxy, error) {
+		b, err := NewBackend(s.backend.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var proxies []*Proxy
+		for _, fqdn := range strings.Split(string(definition), ",") {
+			if fqdn == "" {
+				return nil, fmt.Errorf("empty FQDN")
+			}
+
+			f, err := NewFrontend(fqdn, "proxy", nil)
+			if err != nil {
+				return nil, err
+			}
+
+			p, err := NewProxy(f, b, &noauth.NoAuth{}, http.DefaultTransport, nil)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, p)
+		}
+		return proxies, nil
+	})
+}
+
+func (s *AdminTestSuite) TearDownTest() {
+	s.backend.Close()
+}
+
+func (s *AdminTestSuite) TestServicesLifecycle() {
+	resp := s.admin("PUT", "/admin/services/app", "app.local,*.app.local")
+	s.Equal(http.StatusCreated, resp.StatusCode)
+
+	resp = s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	s.Equal(http.StatusNoContent, s.serve("app.local"))
+	s.Equal(http.StatusNotFound, s.serve("www.app.local"))
+
+	resp = s.admin("GET", "/admin/services", "")
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("application/json", resp.Header.Get("Content-Type"))
+
+	var services []serviceView
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&services))
+	s.Require().Len(services, 1)
+	s.Equal("app", services[0].Name)
+	s.Equal("NoAuth", services[0].Authenticator)
+	s.Equal([]frontendView{{FQDN: "app.local", HTTPHandler: "proxy"}}, services[0].Frontends)
+	s.Require().Len(services[0].Backend.Targets, 1)
+	s.Equal(s.backend.URL, services[0].Backend.Targets[0].URL)
+	s.True(services[0].Backend.Targets[0].Healthy)
+	s.Equal("closed", services[0].Backend.Targets[0].CircuitState)
+
+	resp = s.admin("DELETE", "/admin/services/app", "")
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+	s.Equal(http.StatusNotFound, s.serve("app.local"))
+
+	resp = s.admin("GET", "/admin/services/app", "")
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp = s.admin("DELETE", "/admin/services/app", "")
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *AdminTestSuite) TestPutServiceErrors() {
+	resp := s.admin("PUT", "/admin/services/app", "")
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp = s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusCreated, resp.StatusCode)
+
+	resp = s.admin("PUT", "/admin/services/other", "other.local,APP.local")
+	s.Equal(http.StatusConflict, resp.StatusCode)
+	s.Equal(http.StatusNotFound, s.serve("other.local"))
+
+	resp = s.admin("POST", "/admin/services/app", "app.local")
+	s.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+
+	s.svc.SetServiceBuilder(nil)
+	resp = s.admin("PUT", "/admin/services/new", "new.local")
+	s.Equal(http.StatusNotImplemented, resp.StatusCode)
+}
+
+func (s *AdminTestSuite) TestAuthorization() {
+	resp := s.adminWithToken("PUT", "/admin/services/app", "app.local", "")
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+	s.NotEmpty(resp.Header.Get("WWW-Authenticate"))
+
+	resp = s.adminWithToken("PUT", "/admin/services/app", "app.local", "wrong")
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+	s.Equal(http.StatusNotFound, s.serve("app.local"))
+
+	resp = s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusCreated, resp.StatusCode)
+
+	resp = s.adminWithToken("DELETE", "/admin/services/app", "", "")
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp = s.adminWithToken("PUT", "/admin/services/app/drain", "", "")
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	// Services are shown without the token
+	resp = s.adminWithToken("GET", "/admin/services/app", "", "")
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	// Admin API is read-only without the token set
+	s.svc.SetAdminToken("")
+	resp = s.admin("DELETE", "/admin/services/app", "")
+	s.Equal(http.StatusForbidden, resp.StatusCode)
+	s.Equal(http.StatusNoContent, s.serve("app.local"))
+}
+
+func (s *AdminTestSuite) TestLocalTargets() {
+	// Test builder treats definition as backend URL
+	s.svc.SetServiceBuilder(func(name string, definition []byte) ([]*Proxy, error) {
+		b, err := NewBackend(string(definition), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := NewProxy(&Frontend{FQDN: name + ".local"}, b, nil, http.DefaultTransport, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []*Proxy{p}, nil
+	})
+
+	for _, url := range []string{"file:///etc", "unix:///var/run/docker.sock"} {
+		resp := s.admin("PUT", "/admin/services/app", url)
+		s.Equal(http.StatusForbidden, resp.StatusCode, url)
+		s.Equal(http.StatusNotFound, s.serve("app.local"), url)
+	}
+
+	resp := s.admin("PUT", "/admin/services/app", s.backend.URL)
+	s.Equal(http.StatusCreated, resp.StatusCode)
+}
+
+func (s *AdminTestSuite) TestConfigurationReload() {
+	newProxy := func(fqdn, service string) *Proxy {
+		b, err := NewBackend(s.backend.URL, nil)
+		s.Require().NoError(err)
+
+		p, err := NewProxy(&Frontend{FQDN: fqdn, HTTPHandler: "proxy"}, b, nil, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+		p.Service = service
+		return p
+	}
+
+	s.Require().NoError(s.svc.SetProxies([]*Proxy{newProxy("config.local", "config")}))
+	resp := s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusCreated, resp.StatusCode)
+	resp = s.admin("PUT", "/admin/services/config/drain", "")
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+
+	// Services added and modes set at runtime are kept
+	s.Require().NoError(s.svc.SetProxies([]*Proxy{newProxy("config.local", "config")}))
+	s.Equal(http.StatusNoContent, s.serve("app.local"))
+	s.Equal(http.StatusServiceUnavailable, s.serve("config.local"))
+
+	resp = s.admin("DELETE", "/admin/services/config/drain", "")
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+	s.Require().NoError(s.svc.SetProxies([]*Proxy{newProxy("config.local", "config")}))
+	s.Equal(http.StatusNoContent, s.serve("config.local"))
+
+	// Configuration wins for the hosts it takes
+	s.Require().NoError(s.svc.SetProxies([]*Proxy{newProxy("config.local", "config"), newProxy("app.local", "other")}))
+	resp = s.admin("GET", "/admin/services/app", "")
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+	s.Equal([]string{"config", "other"}, s.svc.ServiceNames())
+
+	s.Require().NoError(s.svc.SetProxies([]*Proxy{newProxy("config.local", "config")}))
+	s.Equal(http.StatusNotFound, s.serve("app.local"))
+}
+
+func (s *AdminTestSuite) TestDrain() {
+	resp := s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusCreated, resp.StatusCode)
+
+	resp = s.admin("PUT", "/admin/services/app/drain", "")
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+	s.Equal(http.StatusServiceUnavailable, s.serve("app.local"))
+
+	// Drain mode is kept on service update
+	resp = s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal(http.StatusServiceUnavailable, s.serve("app.local"))
+
+	resp = s.admin("DELETE", "/admin/services/app/drain", "")
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+	s.Equal(http.StatusNoContent, s.serve("app.local"))
+
+	resp = s.admin("PUT", "/admin/services/unknown/drain", "")
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *AdminTestSuite) TestMaintenance() {
+	resp := s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusCreated, resp.StatusCode)
+
+	resp = s.admin("PUT", "/admin/services/app/maintenance", "")
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+	s.Equal(http.StatusServiceUnavailable, s.serve("app.local"))
+
+	// Maintenance mode is kept on service update
+	resp = s.admin("PUT", "/admin/services/app", "app.local")
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal(http.StatusServiceUnavailable, s.serve("app.local"))
+
+	var service serviceView
+	resp = s.admin("GET", "/admin/services/app", "")
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&service))
+	s.True(service.Maintenance)
+
+	resp = s.admin("DELETE", "/admin/services/app/maintenance", "")
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+	s.Equal(http.StatusNoContent, s.serve("app.local"))
+
+	resp = s.admin("PUT", "/admin/services/unknown/maintenance", "")
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *AdminTestSuite) admin(method, path, body string) *http.Response {
+	return s.adminWithToken(method, path, body, "secret")
+}
+
+func (s *AdminTestSuite) adminWithToken(method, path, body, token string) *http.Response {
+	r, err := http.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	s.Require().NoError(err)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.svc.DebugHandlerFunc(w, r)
+	return w.Result()
+}
+
+func (s *AdminTestSuite) serve(host string) int {
+	r, err := http.NewRequest("GET", "http://"+host+"/", nil)
+	s.Require().NoError(err)
+
+	w := httptest.NewRecorder()
+	s.svc.ServeHTTP(w, r)
+	return w.Result().StatusCode
+}
+
+func (s *AdminTestSuite) TestReplaceDuringHealthCheck() {
+	checking := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only health checks hang, proxied requests carry svcproxy header
+		if r.Header.Get("X-Proxy-App") != "" {
+			return
+		}
+		select {
+		case checking <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer slow.Close()
+
+	b, err := NewBackend(slow.URL, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetHealthCheck(&HealthCheck{
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Minute,
+	}, http.DefaultTransport))
+	f, err := NewFrontend("app.local", "proxy", nil)
+	s.Require().NoError(err)
+	p, err := NewProxy(f, b, &noauth.NoAuth{}, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	_, err = s.svc.PutService("app", []*Proxy{p})
+	s.Require().NoError(err)
+	<-checking
+
+	// Replaced backend is closed once its health check completes
+	done := make(chan struct{})
+	go func() {
+		s.admin("PUT", "/admin/services/app", "app.local")
+		close(done)
+	}()
+
+	// Requests are served by the new backend meanwhile
+	served := make(chan int, 1)
+	go func() {
+		for {
+			if code := s.serve("app.local"); code == http.StatusNoContent {
+				served <- code
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		s.Fail("requests are blocked by closing backend")
+	}
+
+	select {
+	case <-done:
+		s.Fail("backend closed before its health check completed")
+	default:
+	}
+	close(release)
+	<-done
+}
+
+func TestAdminTestSuite(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
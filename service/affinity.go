@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAffinityCookie is the name of affinity cookie used if not specified
+const defaultAffinityCookie = "svcproxy_affinity"
+
+// Affinity defines cookie based session affinity: the target chosen for
+// the first request is stored in signed cookie and subsequent requests
+// are passed to the same target while it's available.
+type Affinity struct {
+	// Cookie is the name of affinity cookie
+	Cookie string
+	// TTL is the cookie lifetime, session cookie is used if zero
+	TTL time.Duration
+	// Secure sets Secure attribute of the cookie
+	Secure bool
+	// SameSite sets SameSite attribute of the cookie:
+	// "lax", "strict", "none" or empty to omit the attribute
+	SameSite string
+	// Secret is the key cookies are signed with, random key is generated
+	// if empty so cookies are valid for the running process only
+	Secret string
+}
+
+// affinity is the prepared Affinity definition
+type affinity struct {
+	cookie   string
+	ttl      time.Duration
+	secure   bool
+	sameSite http.SameSite
+	key      []byte
+}
+
+// SetAffinity enables cookie based session affinity for the backend
+func (b *Backend) SetAffinity(a *Affinity) error {
+	af := &affinity{
+		cookie: a.Cookie,
+		ttl:    a.TTL,
+		secure: a.Secure,
+		key:    []byte(a.Secret),
+	}
+
+	if af.cookie == "" {
+		af.cookie = defaultAffinityCookie
+	}
+	if af.ttl < 0 {
+		return fmt.Errorf("affinity cookie TTL must not be negative")
+	}
+
+	switch strings.ToLower(a.SameSite) {
+	case "":
+	case "lax":
+		af.sameSite = http.SameSiteLaxMode
+	case "strict":
+		af.sameSite = http.SameSiteStrictMode
+	case "none":
+		af.sameSite = http.SameSiteNoneMode
+	default:
+		return fmt.Errorf("unknown sameSite value `%s`", a.SameSite)
+	}
+
+	if len(af.key) == 0 {
+		af.key = make([]byte, 32)
+		if _, err := rand.Read(af.key); err != nil {
+			return err
+		}
+	}
+
+	b.affinity = af
+	return nil
+}
+
+// target returns the target request is bound to by affinity cookie
+// if it's one of the targets passed
+func (af *affinity) target(r *http.Request, targets []*Target) *Target {
+	if af == nil {
+		return nil
+	}
+
+	id, ok := af.cookieTarget(r)
+	if !ok {
+		return nil
+	}
+	for _, t := range targets {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// stick binds client to the target by setting affinity cookie
+// unless request is already bound to it
+func (af *affinity) stick(r *http.Request, resp *http.Response, t *Target) {
+	if af == nil {
+		return
+	}
+
+	if id, ok := af.cookieTarget(r); ok && id == t.id {
+		return
+	}
+
+	var expires int64
+	if af.ttl > 0 {
+		expires = time.Now().Add(af.ttl).Unix()
+	}
+	value := t.id + "." + strconv.FormatInt(expires, 10)
+
+	cookie := &http.Cookie{
+		Name:     af.cookie,
+		Value:    value + "." + af.sign(value),
+		Path:     "/",
+		Secure:   af.secure,
+		HttpOnly: true,
+		SameSite: af.sameSite,
+	}
+	if af.ttl > 0 {
+		cookie.MaxAge = int(af.ttl / time.Second)
+	}
+	resp.Header.Add("Set-Cookie", cookie.String())
+}
+
+// cookieTarget returns target id from valid affinity cookie
+func (af *affinity) cookieTarget(r *http.Request) (string, bool) {
+	c, err := r.Cookie(af.cookie)
+	if err != nil {
+		return "", false
+	}
+
+	i := strings.LastIndex(c.Value, ".")
+	if i < 0 {
+		return "", false
+	}
+	value, sig := c.Value[:i], c.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(af.sign(value))) {
+		return "", false
+	}
+
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", false
+	}
+	if expires > 0 && time.Now().Unix() > expires {
+		return "", false
+	}
+	return parts[0], true
+}
+
+func (af *affinity) sign(value string) string {
+	mac := hmac.New(sha256.New, af.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// targetID returns stable target identifier not disclosing its address
+func targetID(address string) string {
+	sum := sha256.Sum256([]byte(address))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AffinityTestSuite struct {
+	suite.Suite
+}
+
+func (s *AffinityTestSuite) newServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Target", name)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func (s *AffinityTestSuite) serve(p *Proxy, cookie *http.Cookie) (string, *http.Cookie) {
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, r)
+
+	resp := w.Result()
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+
+	var set *http.Cookie
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		set = cookies[0]
+	}
+	return resp.Header.Get("X-Target"), set
+}
+
+func (s *AffinityTestSuite) TestAffinity() {
+	srv1 := s.newServer("first")
+	defer srv1.Close()
+	srv2 := s.newServer("second")
+	defer srv2.Close()
+
+	t1, err := NewTarget(srv1.URL, 1)
+	s.Require().NoError(err)
+	t2, err := NewTarget(srv2.URL, 1)
+	s.Require().NoError(err)
+
+	b, err := NewBalancedBackend([]*Target{t1, t2}, &RoundRobinBalancer{}, nil)
+	s.Require().NoError(err)
+
+	err = b.SetAffinity(&Affinity{
+		Cookie:   "sticky",
+		TTL:      time.Hour,
+		Secure:   true,
+		SameSite: "lax",
+		Secret:   "secret",
+	})
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	target, cookie := s.serve(p, nil)
+	s.Require().NotNil(cookie)
+	s.Equal("sticky", cookie.Name)
+	s.Equal(3600, cookie.MaxAge)
+	s.True(cookie.Secure)
+	s.True(cookie.HttpOnly)
+	s.Equal(http.SameSiteLaxMode, cookie.SameSite)
+
+	// Round robin is bypassed for bound clients
+	for i := 0; i < 5; i++ {
+		tgt, set := s.serve(p, cookie)
+		s.Equal(target, tgt)
+		s.Nil(set)
+	}
+
+	// Tampered cookie is ignored
+	_, set := s.serve(p, &http.Cookie{Name: "sticky", Value: t2.id + ".0.invalid"})
+	s.NotNil(set)
+
+	// Client is moved to another target when bound one is unhealthy
+	bound, other := t1, "second"
+	if target == "second" {
+		bound, other = t2, "first"
+	}
+	bound.setHealthy(false)
+	defer bound.setHealthy(true)
+
+	tgt, set := s.serve(p, cookie)
+	s.Equal(other, tgt)
+	s.Require().NotNil(set)
+
+	for i := 0; i < 3; i++ {
+		tgt, _ := s.serve(p, set)
+		s.Equal(other, tgt)
+	}
+}
+
+func (s *AffinityTestSuite) TestSetAffinityValidation() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	s.Error(b.SetAffinity(&Affinity{SameSite: "unknown"}))
+	s.Error(b.SetAffinity(&Affinity{TTL: -time.Second}))
+
+	s.Require().NoError(b.SetAffinity(&Affinity{}))
+	s.Equal(defaultAffinityCookie, b.affinity.cookie)
+	s.Len(b.affinity.key, 32)
+}
+
+func TestAffinityTestSuite(t *testing.T) {
+	suite.Run(t, new(AffinityTestSuite))
+}
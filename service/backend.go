@@ -1,18 +1,43 @@
 package service
 
 import (
-	"net/url"
+	"errors"
 )
 
-// NewBackend creates new Backend instance
+// NewBackend creates new Backend instance with single target
 func NewBackend(address string, headers map[string]string) (*Backend, error) {
-	backend, err := url.Parse(address)
+	target, err := NewTarget(address, 1)
 	if err != nil {
 		return nil, err
 	}
 
+	return NewBalancedBackend([]*Target{target}, &RoundRobinBalancer{}, headers)
+}
+
+// NewBalancedBackend creates new Backend instance passing requests
+// to the targets chosen by balancer
+func NewBalancedBackend(targets []*Target, balancer Balancer, headers map[string]string) (*Backend, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("at least one target is required for backend")
+	}
+
+	if balancer == nil {
+		balancer = &RoundRobinBalancer{}
+	}
+
 	return &Backend{
-		URL:                backend,
+		URL:                targets[0].URL,
+		Targets:            targets,
+		balancer:           balancer,
 		requestHTTPHeaders: headers,
 	}, nil
 }
+
+// SetService sets the name of the service backend belongs to, target metrics
+// are labelled with it. It must be called before SetHealthCheck and
+// SetCircuitBreaker.
+func (b *Backend) SetService(name string) {
+	for _, t := range b.Targets {
+		t.service = name
+	}
+}
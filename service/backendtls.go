@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// backendTLSReloadInterval is the minimum interval files of backend TLS
+// settings are checked for changes
+var backendTLSReloadInterval = 10 * time.Second
+
+// tlsVersionsByName are TLS versions accepted as minimum ones
+var tlsVersionsByName = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// BackendTLS defines TLS settings of connections to HTTPS targets.
+// Files are checked for changes on handshakes and reloaded when they
+// rotate, previous ones are kept if new ones are invalid.
+type BackendTLS struct {
+	// CAFile is the bundle of CA certificates targets are verified with,
+	// system trust store is used if it's empty
+	CAFile string
+	// CertFile and KeyFile are client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName replaces target host name in SNI and verification
+	ServerName string
+	// MinVersion is minimum TLS version: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+	// Pins are base64 encoded SHA-256 hashes of SubjectPublicKeyInfo one
+	// of the target chain certificates must match, `sha256/` prefix is
+	// allowed
+	Pins []string
+	// InsecureSkipVerify disables verification of target certificates,
+	// pins are checked anyway
+	InsecureSkipVerify bool
+}
+
+// SetTLS sets TLS settings of connections to the backend targets. Requests
+// are passed via the copy of the transport directly to the targets, it must
+// be called after SetProtocol and before SetHealthCheck for health checks
+// to use the settings as well.
+func (b *Backend) SetTLS(cfg *BackendTLS, transport *http.Transport) error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("both client certificate and key files are required")
+	}
+
+	var minVersion uint16
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersionsByName[cfg.MinVersion]
+		if !ok {
+			return fmt.Errorf("unknown TLS version: `%s`", cfg.MinVersion)
+		}
+		minVersion = v
+	}
+
+	var pins [][]byte
+	for _, pin := range cfg.Pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("invalid SPKI pin: `%s`", pin)
+		}
+		pins = append(pins, hash)
+	}
+
+	transport = b.baseTransport(transport)
+
+	c := &backendTLSConfig{
+		cfg:        cfg,
+		minVersion: minVersion,
+		pins:       pins,
+		nextProtos: nextProtos(transport),
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	// Handshake is made by the dial function to use files reloaded
+	// for each new connection. Proxies from environment are skipped,
+	// net/http makes its own handshake through them ignoring the settings.
+	t := transport.Clone()
+	t.Proxy = nil
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tc := tls.Client(conn, c.config(addr))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+
+	b.transport = t
+	return nil
+}
+
+// backendTLSConfig holds the content of backend TLS files reloading
+// it when files change
+type backendTLSConfig struct {
+	cfg        *BackendTLS
+	minVersion uint16
+	pins       [][]byte
+	nextProtos []string
+
+	mutex   sync.Mutex
+	checked time.Time
+	stamp   string
+	roots   *x509.CertPool
+	cert    *tls.Certificate
+}
+
+// load reads the files if they changed since the last load
+func (c *backendTLSConfig) load() error {
+	stamp := c.filesStamp()
+	if c.stamp == stamp {
+		return nil
+	}
+
+	var roots *x509.CertPool
+	if c.cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in `%s`", c.cfg.CAFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if c.cfg.CertFile != "" {
+		kp, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &kp
+	}
+
+	c.stamp, c.roots, c.cert = stamp, roots, cert
+	return nil
+}
+
+// filesStamp returns modification times and sizes of the files
+func (c *backendTLSConfig) filesStamp() string {
+	var stamp bytes.Buffer
+	for _, name := range []string{c.cfg.CAFile, c.cfg.CertFile, c.cfg.KeyFile} {
+		if name == "" {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			fmt.Fprintf(&stamp, "%s:-;", name)
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s:%d:%d;", name, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return stamp.String()
+}
+
+// config returns TLS config of connection to the address, files are
+// checked for changes once in backendTLSReloadInterval
+func (c *backendTLSConfig) config(addr string) *tls.Config {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if time.Since(c.checked) >= backendTLSReloadInterval {
+		c.checked = time.Now()
+		if err := c.load(); err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+				"object": strings.TrimSpace(c.cfg.CAFile + " " + c.cfg.CertFile),
+			}).Warn("Error: unable to reload backend TLS files. Keeping previous ones.")
+		}
+	}
+
+	serverName := c.cfg.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(addr)
+	}
+
+	config := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         c.minVersion,
+		RootCAs:            c.roots,
+		InsecureSkipVerify: c.cfg.InsecureSkipVerify,
+		NextProtos:         c.nextProtos,
+	}
+	if c.cert != nil {
+		config.Certificates = []tls.Certificate{*c.cert}
+	}
+	if len(c.pins) > 0 {
+		config.VerifyConnection = c.verifyPins
+	}
+	return config
+}
+
+// verifyPins checks target certificate chain matches one of the pins,
+// presented certificates are checked if verification is skipped
+func (c *backendTLSConfig) verifyPins(cs tls.ConnectionState) error {
+	chains := cs.VerifiedChains
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates}
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range c.pins {
+				if bytes.Equal(hash[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("target certificate chain doesn't match any of SPKI pins")
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BackendTLSTestSuite struct {
+	suite.Suite
+
+	dir    string
+	ca     *testCertificate
+	server *httptest.Server
+	pin    string
+}
+
+// testCertificate is a certificate with its key, CA ones are able to sign
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func (s *BackendTLSTestSuite) certificate(name string, parent *testCertificate, usage x509.ExtKeyUsage) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.DNSNames = []string{name}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	s.Require().NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	return &testCertificate{cert: cert, key: key, der: der}
+}
+
+// write writes certificate and key files returning their paths
+func (s *BackendTLSTestSuite) write(name string, c *testCertificate) (string, string) {
+	certFile := filepath.Join(s.dir, name+".crt")
+	keyFile := filepath.Join(s.dir, name+".key")
+
+	s.Require().NoError(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644))
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	s.Require().NoError(err)
+	s.Require().NoError(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func (s *BackendTLSTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "svcproxy-backendtls")
+	s.Require().NoError(err)
+	s.dir = dir
+
+	s.ca = s.certificate("Test CA", nil, 0)
+	s.write("ca", s.ca)
+
+	leaf := s.certificate("backend.internal", s.ca, x509.ExtKeyUsageServerAuth)
+	hash := sha256.Sum256(leaf.cert.RawSubjectPublicKeyInfo)
+	s.pin = "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(s.ca.cert)
+
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	s.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.der}, PrivateKey: leaf.key}},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientPool,
+	}
+	s.server.StartTLS()
+}
+
+func (s *BackendTLSTestSuite) TearDownTest() {
+	s.server.Close()
+	os.RemoveAll(s.dir)
+	backendTLSReloadInterval = 10 * time.Second
+}
+
+func (s *BackendTLSTestSuite) TestVerification() {
+	ca := filepath.Join(s.dir, "ca.crt")
+
+	// Target is dialed by IP address the certificate is not issued for
+	s.Equal(http.StatusBadGateway, s.serve(&BackendTLS{CAFile: ca}).Code)
+	s.Equal(http.StatusBadGateway, s.serve(&BackendTLS{ServerName: "backend.internal"}).Code)
+	s.Equal(http.StatusOK, s.serve(&BackendTLS{CAFile: ca, ServerName: "backend.internal"}).Code)
+	s.Equal(http.StatusOK, s.serve(&BackendTLS{InsecureSkipVerify: true}).Code)
+}
+
+func (s *BackendTLSTestSuite) TestClientCertificate() {
+	certFile, keyFile := s.write("client", s.certificate("client.internal", s.ca, x509.ExtKeyUsageClientAuth))
+
+	w := s.serve(&BackendTLS{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true})
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("client.internal", w.Body.String())
+}
+
+func (s *BackendTLSTestSuite) TestPins() {
+	w := s.serve(&BackendTLS{InsecureSkipVerify: true, Pins: []string{s.pin}})
+	s.Equal(http.StatusOK, w.Code)
+
+	other := s.certificate("Other CA", nil, 0)
+	hash := sha256.Sum256(other.cert.RawSubjectPublicKeyInfo)
+	w = s.serve(&BackendTLS{InsecureSkipVerify: true, Pins: []string{base64.StdEncoding.EncodeToString(hash[:])}})
+	s.Equal(http.StatusBadGateway, w.Code)
+
+	// CA certificate of verified chain matches as well
+	hash = sha256.Sum256(s.ca.cert.RawSubjectPublicKeyInfo)
+	w = s.serve(&BackendTLS{
+		CAFile:     filepath.Join(s.dir, "ca.crt"),
+		ServerName: "backend.internal",
+		Pins:       []string{base64.StdEncoding.EncodeToString(hash[:])},
+	})
+	s.Equal(http.StatusOK, w.Code)
+}
+
+func (s *BackendTLSTestSuite) TestReload() {
+	backendTLSReloadInterval = 0
+
+	// CA bundle of another CA is replaced with the right one
+	caFile, _ := s.write("rotated", s.certificate("Other CA", nil, 0))
+	cfg := &BackendTLS{CAFile: caFile, ServerName: "backend.internal"}
+
+	b, err := NewBackend(s.server.URL, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetTLS(cfg, http.DefaultTransport.(*http.Transport)))
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	s.Equal(http.StatusBadGateway, s.proxy(p).Code)
+
+	s.write("rotated", s.ca)
+	mtime := time.Now().Add(time.Minute)
+	s.Require().NoError(os.Chtimes(caFile, mtime, mtime))
+	s.Equal(http.StatusOK, s.proxy(p).Code)
+
+	// Invalid files are skipped keeping previous ones
+	s.Require().NoError(ioutil.WriteFile(caFile, []byte("broken"), 0644))
+	s.Equal(http.StatusOK, s.proxy(p).Code)
+}
+
+func (s *BackendTLSTestSuite) TestProxyFromEnvironment() {
+	// Proxy would make the handshake with default settings
+	proxied := make(chan string, 10)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.Method + " " + r.Host
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	s.Require().NoError(err)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	b, err := NewBackend(s.server.URL, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetTLS(&BackendTLS{
+		CAFile:     filepath.Join(s.dir, "ca.crt"),
+		ServerName: "backend.internal",
+		Pins:       []string{s.pin},
+	}, transport))
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, transport, nil)
+	s.Require().NoError(err)
+
+	s.Equal(http.StatusOK, s.proxy(p).Code)
+	s.Len(proxied, 0)
+}
+
+func (s *BackendTLSTestSuite) TestValidation() {
+	b, err := NewBackend(s.server.URL, nil)
+	s.Require().NoError(err)
+	t := http.DefaultTransport.(*http.Transport)
+
+	s.Error(b.SetTLS(&BackendTLS{MinVersion: "1.4"}, t))
+	s.Error(b.SetTLS(&BackendTLS{Pins: []string{"c2hvcnQ="}}, t))
+	s.Error(b.SetTLS(&BackendTLS{CertFile: "client.crt"}, t))
+	s.Error(b.SetTLS(&BackendTLS{CAFile: filepath.Join(s.dir, "missing.crt")}, t))
+	s.NoError(b.SetTLS(&BackendTLS{MinVersion: "1.3", Pins: []string{s.pin}}, t))
+}
+
+func (s *BackendTLSTestSuite) serve(cfg *BackendTLS) *httptest.ResponseRecorder {
+	b, err := NewBackend(s.server.URL, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetTLS(cfg, http.DefaultTransport.(*http.Transport)))
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	return s.proxy(p)
+}
+
+func (s *BackendTLSTestSuite) proxy(p *Proxy) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	w := httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, r)
+	return w
+}
+
+func TestBackendTLSTestSuite(t *testing.T) {
+	suite.Run(t, new(BackendTLSTestSuite))
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer chooses the target to pass request to
+type Balancer interface {
+	Next(r *http.Request, targets []*Target) *Target
+}
+
+var (
+	_ Balancer = &RoundRobinBalancer{}
+	_ Balancer = &WeightedRoundRobinBalancer{}
+	_ Balancer = &LeastRequestsBalancer{}
+	_ Balancer = &RandomTwoChoicesBalancer{}
+	_ Balancer = &HashBalancer{}
+)
+
+// NewBalancer returns specific balancer based on configuration
+func NewBalancer(method string, options map[string]string) (Balancer, error) {
+	switch method {
+	// Round robin is used by default
+	case "", "roundRobin":
+		return &RoundRobinBalancer{}, nil
+	case "weightedRoundRobin":
+		return &WeightedRoundRobinBalancer{}, nil
+	case "leastRequests":
+		return &LeastRequestsBalancer{}, nil
+	case "randomTwoChoices":
+		return &RandomTwoChoicesBalancer{}, nil
+	case "hash":
+		return NewHashBalancer(options["hashBy"], options["key"])
+	}
+	return nil, fmt.Errorf("Unknown balancer %s", method)
+}
+
+// RoundRobinBalancer passes requests to targets one by one
+type RoundRobinBalancer struct {
+	counter uint64
+}
+
+// Next returns next target
+func (b *RoundRobinBalancer) Next(r *http.Request, targets []*Target) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&b.counter, 1)
+	return targets[(n-1)%uint64(len(targets))]
+}
+
+// WeightedRoundRobinBalancer implements smooth weighted round robin
+// the same way nginx does: targets are picked proportionally to their
+// weights but interleaved instead of being picked in batches.
+type WeightedRoundRobinBalancer struct {
+	mutex   sync.Mutex
+	current map[*Target]int
+}
+
+// Next returns next target
+func (b *WeightedRoundRobinBalancer) Next(r *http.Request, targets []*Target) *Target {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.current == nil {
+		b.current = make(map[*Target]int)
+	}
+
+	var best *Target
+	total := 0
+	for _, t := range targets {
+		b.current[t] += t.Weight
+		total += t.Weight
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+
+	if best != nil {
+		b.current[best] -= total
+	}
+	return best
+}
+
+// LeastRequestsBalancer passes request to the target with the least amount
+// of outstanding requests taking target weights into account
+type LeastRequestsBalancer struct {
+	RoundRobinBalancer
+}
+
+// Next returns next target
+func (b *LeastRequestsBalancer) Next(r *http.Request, targets []*Target) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	// Start from different positions to spread requests between
+	// equally loaded targets
+	offset := int(atomic.AddUint64(&b.counter, 1) % uint64(len(targets)))
+
+	var best *Target
+	for i := range targets {
+		t := targets[(i+offset)%len(targets)]
+		if best == nil || lessLoaded(t, best) {
+			best = t
+		}
+	}
+	return best
+}
+
+// RandomTwoChoicesBalancer picks two random targets and passes request
+// to the one with less outstanding requests
+type RandomTwoChoicesBalancer struct{}
+
+// Next returns next target
+func (b *RandomTwoChoicesBalancer) Next(r *http.Request, targets []*Target) *Target {
+	switch len(targets) {
+	case 0:
+		return nil
+	case 1:
+		return targets[0]
+	}
+
+	i := rand.Intn(len(targets))
+	j := rand.Intn(len(targets) - 1)
+	if j >= i {
+		j++
+	}
+
+	if lessLoaded(targets[j], targets[i]) {
+		return targets[j]
+	}
+	return targets[i]
+}
+
+// HashBalancer passes requests with the same key(header, cookie or client IP)
+// to the same target. Rendezvous hashing is used so only requests for
+// the keys owned by disappeared target are moved to other targets.
+type HashBalancer struct {
+	hashBy string
+	key    string
+}
+
+// NewHashBalancer creates new HashBalancer instance
+func NewHashBalancer(hashBy, key string) (*HashBalancer, error) {
+	switch hashBy {
+	case "header", "cookie":
+		if key == "" {
+			return nil, fmt.Errorf("key option is required to hash by %s", hashBy)
+		}
+	case "clientIP":
+	default:
+		return nil, fmt.Errorf("unknown hashBy option value `%s`", hashBy)
+	}
+
+	return &HashBalancer{
+		hashBy: hashBy,
+		key:    key,
+	}, nil
+}
+
+// Next returns next target
+func (b *HashBalancer) Next(r *http.Request, targets []*Target) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	key := b.requestKey(r)
+	if key == "" {
+		return targets[rand.Intn(len(targets))]
+	}
+
+	var best *Target
+	bestScore := math.Inf(-1)
+	for _, t := range targets {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(t.URL.String()))
+
+		// Weighted rendezvous hashing: score = -weight / ln(hash normalized to (0, 1))
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(t.Weight) / math.Log(u)
+		if score > bestScore {
+			best = t
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func (b *HashBalancer) requestKey(r *http.Request) string {
+	switch b.hashBy {
+	case "header":
+		return r.Header.Get(b.key)
+	case "cookie":
+		c, err := r.Cookie(b.key)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	case "clientIP":
+		return clientFromRequest(r).IP
+	}
+	return ""
+}
+
+// lessLoaded compares outstanding requests per weight unit for the targets
+func lessLoaded(a, b *Target) bool {
+	return a.Outstanding()*int64(b.Weight) < b.Outstanding()*int64(a.Weight)
+}
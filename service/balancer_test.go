@@ -0,0 +1,221 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BalancerTestSuite struct {
+	suite.Suite
+}
+
+func (s *BalancerTestSuite) newTargets(weights ...int) []*Target {
+	var targets []*Target
+	for i, w := range weights {
+		t, err := NewTarget("http://target"+string(rune('a'+i))+".local", w)
+		s.Require().NoError(err)
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+func (s *BalancerTestSuite) distribution(b Balancer, targets []*Target, n int, r func(int) *http.Request) map[*Target]int {
+	result := make(map[*Target]int)
+	for i := 0; i < n; i++ {
+		t := b.Next(r(i), targets)
+		s.Require().NotNil(t)
+		result[t]++
+	}
+	return result
+}
+
+func (s *BalancerTestSuite) plainRequest(int) *http.Request {
+	r, err := http.NewRequest("GET", "http://test.local/", nil)
+	s.Require().NoError(err)
+	return r
+}
+
+func (s *BalancerTestSuite) TestRoundRobin() {
+	targets := s.newTargets(1, 1, 1)
+	b, err := NewBalancer("roundRobin", nil)
+	s.Require().NoError(err)
+
+	d := s.distribution(b, targets, 300, s.plainRequest)
+	for _, t := range targets {
+		s.Equal(100, d[t])
+	}
+}
+
+func (s *BalancerTestSuite) TestWeightedRoundRobin() {
+	targets := s.newTargets(5, 1, 1)
+	b, err := NewBalancer("weightedRoundRobin", nil)
+	s.Require().NoError(err)
+
+	// Smooth weighted round robin never picks the same target
+	// more often than the weight requires
+	var sequence []*Target
+	for i := 0; i < 7; i++ {
+		sequence = append(sequence, b.Next(s.plainRequest(i), targets))
+	}
+	s.Equal([]*Target{targets[0], targets[0], targets[1], targets[0], targets[2], targets[0], targets[0]}, sequence)
+
+	d := s.distribution(b, targets, 700, s.plainRequest)
+	s.Equal(500, d[targets[0]])
+	s.Equal(100, d[targets[1]])
+	s.Equal(100, d[targets[2]])
+}
+
+func (s *BalancerTestSuite) TestLeastRequests() {
+	targets := s.newTargets(1, 1, 2)
+	atomic.StoreInt64(&targets[0].outstanding, 3)
+	atomic.StoreInt64(&targets[1].outstanding, 1)
+	atomic.StoreInt64(&targets[2].outstanding, 4)
+
+	b, err := NewBalancer("leastRequests", nil)
+	s.Require().NoError(err)
+
+	for i := 0; i < 10; i++ {
+		s.Equal(targets[1], b.Next(s.plainRequest(i), targets))
+	}
+
+	atomic.StoreInt64(&targets[1].outstanding, 3)
+	for i := 0; i < 10; i++ {
+		s.Equal(targets[2], b.Next(s.plainRequest(i), targets))
+	}
+}
+
+func (s *BalancerTestSuite) TestRandomTwoChoices() {
+	targets := s.newTargets(1, 1)
+	atomic.StoreInt64(&targets[0].outstanding, 10)
+
+	b, err := NewBalancer("randomTwoChoices", nil)
+	s.Require().NoError(err)
+
+	d := s.distribution(b, targets, 100, s.plainRequest)
+	s.Equal(100, d[targets[1]])
+
+	targets = s.newTargets(1, 1, 1, 1)
+	d = s.distribution(b, targets, 1000, s.plainRequest)
+	s.Len(d, 4)
+}
+
+func (s *BalancerTestSuite) TestHash() {
+	targets := s.newTargets(1, 1, 1, 1)
+
+	_, err := NewBalancer("hash", map[string]string{"hashBy": "header"})
+	s.Error(err)
+
+	_, err = NewBalancer("hash", map[string]string{"hashBy": "body"})
+	s.Error(err)
+
+	b, err := NewBalancer("hash", map[string]string{"hashBy": "header", "key": "X-User-ID"})
+	s.Require().NoError(err)
+
+	keyRequest := func(i int) *http.Request {
+		r := s.plainRequest(i)
+		r.Header.Set("X-User-ID", string(rune('a'+i%20)))
+		return r
+	}
+
+	owners := make(map[string]*Target)
+	for i := 0; i < 100; i++ {
+		r := keyRequest(i)
+		t := b.Next(r, targets)
+		key := r.Header.Get("X-User-ID")
+		if owner, ok := owners[key]; ok {
+			s.Equal(owner, t)
+		}
+		owners[key] = t
+	}
+
+	// Removing the target moves only the keys it owned
+	for key, owner := range owners {
+		r := s.plainRequest(0)
+		r.Header.Set("X-User-ID", key)
+		t := b.Next(r, targets[1:])
+		if owner != targets[0] {
+			s.Equal(owner, t)
+		}
+	}
+
+	b, err = NewBalancer("hash", map[string]string{"hashBy": "clientIP"})
+	s.Require().NoError(err)
+
+	r := s.plainRequest(0)
+	r.RemoteAddr = "192.0.2.1:40000"
+	t := b.Next(r, targets)
+	r.RemoteAddr = "192.0.2.1:50000"
+	s.Equal(t, b.Next(r, targets))
+
+	b, err = NewBalancer("hash", map[string]string{"hashBy": "cookie", "key": "session"})
+	s.Require().NoError(err)
+
+	r = s.plainRequest(0)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abcdef"})
+	t = b.Next(r, targets)
+	s.Equal(t, b.Next(r, targets))
+}
+
+func (s *BalancerTestSuite) TestUnknownBalancer() {
+	_, err := NewBalancer("fastest", nil)
+	s.Error(err)
+}
+
+func (s *BalancerTestSuite) TestBalancedBackend() {
+	var hits [2]int64
+	newServer := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&hits[i], 1)
+			s.Equal("/prefix/blah", r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+
+	srv1 := newServer(0)
+	defer srv1.Close()
+	srv2 := newServer(1)
+	defer srv2.Close()
+
+	t1, err := NewTarget(srv1.URL+"/prefix", 1)
+	s.Require().NoError(err)
+	t2, err := NewTarget(srv2.URL+"/prefix", 1)
+	s.Require().NoError(err)
+
+	b, err := NewBalancedBackend([]*Target{t1, t2}, &RoundRobinBalancer{}, nil)
+	s.Require().NoError(err)
+
+	f, err := NewFrontend("test.local", "proxy", nil)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+	svc.AddProxy(p)
+
+	for i := 0; i < 10; i++ {
+		r, err := http.NewRequest("GET", "http://test.local/blah", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, r)
+		s.Equal(http.StatusNoContent, w.Result().StatusCode)
+	}
+
+	s.Equal(int64(5), atomic.LoadInt64(&hits[0]))
+	s.Equal(int64(5), atomic.LoadInt64(&hits[1]))
+	s.Equal(int64(0), t1.Outstanding())
+	s.Equal(int64(0), t2.Outstanding())
+
+	_, err = NewBalancedBackend(nil, nil, nil)
+	s.Error(err)
+}
+
+func TestBalancerTestSuite(t *testing.T) {
+	suite.Run(t, new(BalancerTestSuite))
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// CircuitState is the state of target's circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed passes requests to the target
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects requests to the target until cool down is over
+	CircuitOpen
+
+	// CircuitHalfOpen passes limited amount of requests to the target
+	// to find out if it's recovered
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var targetCircuitState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "backend_target_circuit_state",
+		Help: "A gauge of backend target circuit breaker state: 0 for closed, 1 for open and 2 for half-open.",
+	},
+	[]string{"service", "target"},
+)
+
+func init() {
+	prometheus.MustRegister(targetCircuitState)
+}
+
+// CircuitBreaker defines passive outlier detection for backend targets
+type CircuitBreaker struct {
+	// ConsecutiveFailures is amount of consecutive failed requests
+	// (connection errors and 5xx responses) to open the circuit
+	ConsecutiveFailures int
+	// CoolDown is the time circuit stays open before
+	// trial requests are passed to the target
+	CoolDown time.Duration
+	// HalfOpenRequests is amount of concurrent trial requests passed
+	// to the target in half-open state
+	HalfOpenRequests int
+}
+
+// circuit is the per-target circuit breaker state machine
+type circuit struct {
+	mutex    sync.Mutex
+	config   *CircuitBreaker
+	target   *Target
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+// SetCircuitBreaker enables circuit breaking for the backend targets
+func (b *Backend) SetCircuitBreaker(cb *CircuitBreaker) error {
+	if cb.ConsecutiveFailures <= 0 {
+		return fmt.Errorf("consecutive failures threshold must be positive")
+	}
+	if cb.CoolDown <= 0 {
+		return fmt.Errorf("circuit breaker cool down must be positive")
+	}
+	if cb.HalfOpenRequests <= 0 {
+		cb.HalfOpenRequests = 1
+	}
+
+	b.exposeMetrics()
+	for _, t := range b.Targets {
+		t.circuit = &circuit{
+			config: cb,
+			target: t,
+		}
+		setTargetGauge(targetCircuitState, t, float64(CircuitClosed))
+	}
+	return nil
+}
+
+// CircuitState returns state of target's circuit breaker
+func (t *Target) CircuitState() CircuitState {
+	if t.circuit == nil {
+		return CircuitClosed
+	}
+
+	t.circuit.mutex.Lock()
+	defer t.circuit.mutex.Unlock()
+
+	t.circuit.refresh()
+	return t.circuit.state
+}
+
+// allows checks if circuit lets the request to pass
+func (c *circuit) allows() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.refresh()
+	switch c.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return c.trials < c.config.HalfOpenRequests
+	}
+	return true
+}
+
+// acquire is called when request is passed to the target
+func (c *circuit) acquire() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.refresh()
+	if c.state == CircuitHalfOpen {
+		c.trials++
+	}
+}
+
+// report records request result
+func (c *circuit) report(success bool) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch c.state {
+	case CircuitClosed:
+		if success {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= c.config.ConsecutiveFailures {
+			c.setState(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if c.trials > 0 {
+			c.trials--
+		}
+		if success {
+			c.setState(CircuitClosed)
+			return
+		}
+		c.setState(CircuitOpen)
+	}
+}
+
+// release gives back trial request slot of the request which result
+// says nothing about the target, e.g. cancelled by the client
+func (c *circuit) release() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state == CircuitHalfOpen && c.trials > 0 {
+		c.trials--
+	}
+}
+
+// refresh moves open circuit to half-open state when cool down is over
+func (c *circuit) refresh() {
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= c.config.CoolDown {
+		c.setState(CircuitHalfOpen)
+	}
+}
+
+func (c *circuit) setState(state CircuitState) {
+	c.state = state
+	c.failures = 0
+	c.trials = 0
+	if state == CircuitOpen {
+		c.openedAt = time.Now()
+	}
+
+	log.WithFields(log.Fields{
+		"target": c.target.URL.String(),
+		"state":  state.String(),
+	}).Warn("Target circuit breaker state changed")
+	setTargetGauge(targetCircuitState, c.target, float64(state))
+}
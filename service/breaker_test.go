@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CircuitBreakerTestSuite struct {
+	suite.Suite
+}
+
+func (s *CircuitBreakerTestSuite) TestStateMachine() {
+	t, err := NewTarget("http://localhost", 1)
+	s.Require().NoError(err)
+
+	b, err := NewBalancedBackend([]*Target{t}, nil, nil)
+	s.Require().NoError(err)
+
+	err = b.SetCircuitBreaker(&CircuitBreaker{
+		ConsecutiveFailures: 3,
+		CoolDown:            20 * time.Millisecond,
+	})
+	s.Require().NoError(err)
+
+	t.circuit.report(false)
+	t.circuit.report(false)
+	t.circuit.report(true)
+	t.circuit.report(false)
+	t.circuit.report(false)
+	s.Equal(CircuitClosed, t.CircuitState())
+
+	t.circuit.report(false)
+	s.Equal(CircuitOpen, t.CircuitState())
+	s.Empty(b.available())
+
+	time.Sleep(25 * time.Millisecond)
+	s.Equal(CircuitHalfOpen, t.CircuitState())
+	s.Len(b.available(), 1)
+
+	// Only single trial request is allowed in half-open state
+	t.circuit.acquire()
+	s.Empty(b.available())
+
+	// Failed trial opens circuit again
+	t.circuit.report(false)
+	s.Equal(CircuitOpen, t.CircuitState())
+
+	time.Sleep(25 * time.Millisecond)
+	t.circuit.acquire()
+	t.circuit.report(true)
+	s.Equal(CircuitClosed, t.CircuitState())
+}
+
+func (s *CircuitBreakerTestSuite) TestFailFast() {
+	var hits int64
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer testsrv.Close()
+
+	b, err := NewBackend(testsrv.URL, nil)
+	s.Require().NoError(err)
+
+	err = b.SetCircuitBreaker(&CircuitBreaker{
+		ConsecutiveFailures: 2,
+		CoolDown:            time.Minute,
+	})
+	s.Require().NoError(err)
+
+	f, err := NewFrontend("test.local", "proxy", nil)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	expected := []int{
+		http.StatusInternalServerError,
+		http.StatusInternalServerError,
+		http.StatusServiceUnavailable,
+		http.StatusServiceUnavailable,
+	}
+	for _, status := range expected {
+		r, err := http.NewRequest("GET", "http://test.local/", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		p.proxy.ServeHTTP(w, r)
+		s.Equal(status, w.Result().StatusCode)
+	}
+	s.Equal(int64(2), atomic.LoadInt64(&hits))
+}
+
+func (s *CircuitBreakerTestSuite) TestConnectionErrors() {
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	addr := testsrv.URL
+	testsrv.Close()
+
+	b, err := NewBackend(addr, nil)
+	s.Require().NoError(err)
+
+	err = b.SetCircuitBreaker(&CircuitBreaker{
+		ConsecutiveFailures: 1,
+		CoolDown:            time.Minute,
+	})
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		r, err := http.NewRequest("GET", "http://test.local/", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		p.proxy.ServeHTTP(w, r)
+		s.Equal(status, w.Result().StatusCode)
+	}
+}
+
+func (s *CircuitBreakerTestSuite) TestCancelledTrial() {
+	var hits int64
+	started := make(chan struct{})
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt64(&hits, 1) {
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 2:
+			close(started)
+			<-r.Context().Done()
+		}
+	}))
+	defer testsrv.Close()
+
+	b, err := NewBackend(testsrv.URL, nil)
+	s.Require().NoError(err)
+
+	err = b.SetCircuitBreaker(&CircuitBreaker{
+		ConsecutiveFailures: 1,
+		CoolDown:            20 * time.Millisecond,
+	})
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	serve := func(ctx context.Context) int {
+		r, err := http.NewRequest("GET", "http://test.local/", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		p.proxy.ServeHTTP(w, r.WithContext(ctx))
+		return w.Result().StatusCode
+	}
+
+	s.Equal(http.StatusInternalServerError, serve(context.Background()))
+	s.Equal(CircuitOpen, b.Targets[0].CircuitState())
+	time.Sleep(25 * time.Millisecond)
+
+	// Trial request cancelled by the client gives its slot back
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	serve(ctx)
+	s.Equal(CircuitHalfOpen, b.Targets[0].CircuitState())
+
+	s.Equal(http.StatusOK, serve(context.Background()))
+	s.Equal(CircuitClosed, b.Targets[0].CircuitState())
+}
+
+func (s *CircuitBreakerTestSuite) TestInvalidConfig() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	s.Error(b.SetCircuitBreaker(&CircuitBreaker{CoolDown: time.Second}))
+	s.Error(b.SetCircuitBreaker(&CircuitBreaker{ConsecutiveFailures: 1}))
+}
+
+func TestCircuitBreakerTestSuite(t *testing.T) {
+	suite.Run(t, new(CircuitBreakerTestSuite))
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DefaultActionNotFound responds with 404 Not Found
+	DefaultActionNotFound = "notFound"
+	// DefaultActionProxy passes requests to the default proxy
+	DefaultActionProxy = "proxy"
+	// DefaultActionRedirect redirects requests to the canonical URL
+	DefaultActionRedirect = "redirect"
+	// DefaultActionStatic responds with the static page
+	DefaultActionStatic = "static"
+	// DefaultActionClose closes connection without response
+	DefaultActionClose = "close"
+)
+
+// DefaultService handles requests for the hosts not served by any proxy
+type DefaultService struct {
+	Action string
+	// Proxy handles requests if action is proxy
+	Proxy *Proxy
+	// RedirectURL is the URL requests are redirected to, path and query
+	// of the request are appended to it if PreservePath is set
+	RedirectURL  string
+	PreservePath bool
+	// StatusCode of redirect(302 by default) and static(404 by default)
+	// responses
+	StatusCode  int
+	Body        []byte
+	ContentType string
+}
+
+// Validate checks default service settings and fills defaults
+func (ds *DefaultService) Validate() error {
+	if ds == nil {
+		return nil
+	}
+
+	switch ds.Action {
+	case "":
+		ds.Action = DefaultActionNotFound
+	case DefaultActionNotFound, DefaultActionClose:
+	case DefaultActionProxy:
+		if ds.Proxy == nil {
+			return fmt.Errorf("proxy is required for `%s` action", ds.Action)
+		}
+	case DefaultActionRedirect:
+		u, err := url.Parse(ds.RedirectURL)
+		if err != nil {
+			return err
+		}
+		if !u.IsAbs() {
+			return fmt.Errorf("redirect URL must be absolute: `%s`", ds.RedirectURL)
+		}
+		if ds.StatusCode == 0 {
+			ds.StatusCode = http.StatusFound
+		}
+		if ds.StatusCode < 300 || ds.StatusCode > 399 {
+			return fmt.Errorf("invalid redirect status code: %d", ds.StatusCode)
+		}
+	case DefaultActionStatic:
+		if ds.StatusCode == 0 {
+			ds.StatusCode = http.StatusNotFound
+		}
+		if ds.StatusCode < 200 || ds.StatusCode > 599 {
+			return fmt.Errorf("invalid status code: %d", ds.StatusCode)
+		}
+		if ds.ContentType == "" {
+			ds.ContentType = "text/html; charset=utf-8"
+		}
+	default:
+		return fmt.Errorf("unknown default service action: `%s`", ds.Action)
+	}
+	return nil
+}
+
+// SetDefaultService sets the service handling requests for unknown hosts,
+// requests get 404 Not Found if it's nil. Backends of the replaced default
+// proxy are closed.
+func (s *Svc) SetDefaultService(ds *DefaultService) error {
+	if err := ds.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	old := s.defaultService
+	s.defaultService = ds
+	s.mutex.Unlock()
+
+	if old != nil && old.Proxy != nil && (ds == nil || ds.Proxy != old.Proxy) {
+		CloseProxies([]*Proxy{old.Proxy})
+	}
+	return nil
+}
+
+// defaultProxy handles request for unknown host according to the default
+// service action, the proxy is returned if request should be proxied
+func (s *Svc) defaultProxy(w http.ResponseWriter, r *http.Request) *Proxy {
+	s.mutex.RLock()
+	ds := s.defaultService
+	s.mutex.RUnlock()
+
+	if ds == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	switch ds.Action {
+	case DefaultActionProxy:
+		return ds.Proxy
+	case DefaultActionRedirect:
+		redirURL := ds.RedirectURL
+		if ds.PreservePath {
+			redirURL = strings.TrimSuffix(redirURL, "/") + r.URL.RequestURI()
+		}
+		http.Redirect(w, r, redirURL, ds.StatusCode)
+	case DefaultActionStatic:
+		// Static page is configured explicitly so it's not replaced
+		// by error pages
+		if st := errorPageStateFromRequest(r); st != nil {
+			st.keepBody = true
+		}
+		w.Header().Set("Content-Type", ds.ContentType)
+		w.WriteHeader(ds.StatusCode)
+		w.Write(ds.Body)
+	case DefaultActionClose:
+		// Server closes connection without response and doesn't log
+		// the panic
+		panic(http.ErrAbortHandler)
+	default:
+		http.NotFound(w, r)
+	}
+	return nil
+}
+
+// SetFallbackCertificate sets certificate used in TLS handshakes for
+// the hosts not served by any proxy
+func (s *Svc) SetFallbackCertificate(cert *tls.Certificate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.fallbackCertificate = cert
+}
+
+// GetCertificate returns tls.Config.GetCertificate function passing
+// handshakes for hosts served to the next function, the fallback
+// certificate is used for unknown hosts and clients without SNI
+func (s *Svc) GetCertificate(next func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		s.mutex.RLock()
+		cert := s.fallbackCertificate
+		s.mutex.RUnlock()
+
+		if cert != nil {
+			if _, ok := s.lookup(hello.ServerName); !ok {
+				return cert, nil
+			}
+		}
+		return next(hello)
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DefaultServiceTestSuite struct {
+	suite.Suite
+}
+
+func (s *DefaultServiceTestSuite) serve(svc *Svc, url string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	svc.ErrorPagesHandler(svc).ServeHTTP(w, r)
+	return w
+}
+
+func (s *DefaultServiceTestSuite) TestNotFound() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	s.Equal(http.StatusNotFound, s.serve(svc, "http://unknown.local/").Code)
+
+	s.Require().NoError(svc.SetDefaultService(&DefaultService{}))
+	s.Equal(http.StatusNotFound, s.serve(svc, "http://unknown.local/").Code)
+}
+
+func (s *DefaultServiceTestSuite) TestRedirect() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	s.Require().NoError(svc.SetDefaultService(&DefaultService{
+		Action:      DefaultActionRedirect,
+		RedirectURL: "https://example.com/",
+	}))
+	w := s.serve(svc, "http://unknown.local/path?q=1")
+	s.Equal(http.StatusFound, w.Code)
+	s.Equal("https://example.com/", w.Header().Get("Location"))
+
+	s.Require().NoError(svc.SetDefaultService(&DefaultService{
+		Action:       DefaultActionRedirect,
+		RedirectURL:  "https://example.com/",
+		PreservePath: true,
+		StatusCode:   http.StatusMovedPermanently,
+	}))
+	w = s.serve(svc, "http://unknown.local/path?q=1")
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("https://example.com/path?q=1", w.Header().Get("Location"))
+}
+
+func (s *DefaultServiceTestSuite) TestStatic() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+	s.Require().NoError(svc.SetErrorPages(&ErrorPages{
+		HTML: map[int]string{0: "error page"},
+	}))
+
+	s.Require().NoError(svc.SetDefaultService(&DefaultService{
+		Action: DefaultActionStatic,
+		Body:   []byte("no such site"),
+	}))
+
+	// Static page is not replaced by error page
+	w := s.serve(svc, "http://unknown.local/")
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("no such site", w.Body.String())
+}
+
+func (s *DefaultServiceTestSuite) TestProxy() {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("default " + r.Host))
+	}))
+	defer backend.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	b, err := NewBackend(backend.URL, nil)
+	s.Require().NoError(err)
+	p, err := NewProxy(&Frontend{}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	s.Require().NoError(svc.SetDefaultService(&DefaultService{
+		Action: DefaultActionProxy,
+		Proxy:  p,
+	}))
+
+	w := s.serve(svc, "http://unknown.local/")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("default unknown.local", w.Body.String())
+}
+
+func (s *DefaultServiceTestSuite) TestClose() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+	s.Require().NoError(svc.SetDefaultService(&DefaultService{Action: DefaultActionClose}))
+
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	_, err = http.Get(srv.URL)
+	s.Error(err)
+}
+
+func (s *DefaultServiceTestSuite) TestFallbackCertificate() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+	s.Require().NoError(svc.AddProxy(&Proxy{Frontend: &Frontend{FQDN: "test.local"}}))
+
+	served := &tls.Certificate{}
+	getCertificate := svc.GetCertificate(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return served, nil
+	})
+
+	// Unknown hosts are passed to the next function without fallback
+	cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: "unknown.local"})
+	s.Require().NoError(err)
+	s.True(cert == served)
+
+	fallback := &tls.Certificate{}
+	svc.SetFallbackCertificate(fallback)
+
+	cert, err = getCertificate(&tls.ClientHelloInfo{ServerName: "test.local"})
+	s.Require().NoError(err)
+	s.True(cert == served)
+
+	cert, err = getCertificate(&tls.ClientHelloInfo{ServerName: "unknown.local"})
+	s.Require().NoError(err)
+	s.True(cert == fallback)
+
+	cert, err = getCertificate(&tls.ClientHelloInfo{})
+	s.Require().NoError(err)
+	s.True(cert == fallback)
+}
+
+func (s *DefaultServiceTestSuite) TestValidation() {
+	s.Error((&DefaultService{Action: "unknown"}).Validate())
+	s.Error((&DefaultService{Action: DefaultActionProxy}).Validate())
+	s.Error((&DefaultService{Action: DefaultActionRedirect, RedirectURL: "/relative"}).Validate())
+	s.Error((&DefaultService{Action: DefaultActionRedirect, RedirectURL: "https://example.com", StatusCode: 200}).Validate())
+	s.NoError((&DefaultService{Action: DefaultActionClose}).Validate())
+}
+
+func TestDefaultServiceTestSuite(t *testing.T) {
+	suite.Run(t, new(DefaultServiceTestSuite))
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorPages defines bodies of error responses. Pages are keyed by status
+// code, the page with 0 key is used for statuses without their own page.
+// Pages could refer to request variables available for header rules and
+// `$status` and `$status_text` ones, values are escaped for HTML and JSON.
+type ErrorPages struct {
+	HTML map[int]string
+	JSON map[int]string
+	// InterceptUpstream replaces bodies of backend responses with 5xx status,
+	// other backend responses are passed as is
+	InterceptUpstream bool
+}
+
+// errorPageVariables are the variables available in error pages only
+var errorPageVariables = map[string]string{
+	"status":      "",
+	"status_text": "",
+}
+
+// Validate checks pages refer known variables only
+func (ep *ErrorPages) Validate() error {
+	if ep == nil {
+		return nil
+	}
+
+	for _, pages := range []map[int]string{ep.HTML, ep.JSON} {
+		for status, page := range pages {
+			if status != 0 && (status < 400 || status > 599) {
+				return fmt.Errorf("error page status must be between 400 and 599: %d", status)
+			}
+			if unknown := unknownVariable(page, errorPageVariables); unknown != "" {
+				return fmt.Errorf("unknown variable in error page for status %d: `%s`", status, unknown)
+			}
+		}
+	}
+	return nil
+}
+
+// SetErrorPages sets error pages used for all the services
+func (s *Svc) SetErrorPages(ep *ErrorPages) error {
+	if err := ep.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errorPages = ep
+	return nil
+}
+
+// SetErrorPages sets error pages of the proxy taking precedence
+// over the global ones
+func (p *Proxy) SetErrorPages(ep *ErrorPages) error {
+	if err := ep.Validate(); err != nil {
+		return err
+	}
+
+	p.errorPages = ep
+	return nil
+}
+
+// page returns the page of the status in one of the formats
+// preferred by client, format is chosen before the status
+// so default page of preferred format wins
+func (ep *ErrorPages) page(status int, preferJSON bool) (page string, contentType string, ok bool) {
+	if ep == nil {
+		return "", "", false
+	}
+
+	lookup := func(pages map[int]string) (string, bool) {
+		if page, ok := pages[status]; ok {
+			return page, true
+		}
+		page, ok := pages[0]
+		return page, ok
+	}
+
+	htmlPage, hasHTML := lookup(ep.HTML)
+	jsonPage, hasJSON := lookup(ep.JSON)
+
+	switch {
+	case hasJSON && (preferJSON || !hasHTML):
+		return jsonPage, "application/json; charset=utf-8", true
+	case hasHTML:
+		return htmlPage, "text/html; charset=utf-8", true
+	}
+	return "", "", false
+}
+
+type errorPageStateKey struct{}
+
+// errorPageState is filled while request is handled to choose
+// and render error page
+type errorPageState struct {
+	proxy    *Proxy
+	vars     *requestVars
+	upstream bool
+	// keepBody is set for responses with explicitly configured body
+	keepBody bool
+}
+
+func errorPageStateFromRequest(r *http.Request) *errorPageState {
+	st, _ := r.Context().Value(errorPageStateKey{}).(*errorPageState)
+	return st
+}
+
+// ErrorPagesHandler replaces bodies of error responses of the next handler
+// with the error pages. It should wrap middlewares so their responses
+// are replaced as well.
+func (s *Svc) ErrorPagesHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &errorPageState{}
+		r = r.WithContext(context.WithValue(r.Context(), errorPageStateKey{}, st))
+
+		next.ServeHTTP(&errorPageWriter{ResponseWriter: w, r: r, svc: s, state: st}, r)
+	})
+}
+
+// errorPage returns rendered error page for the response status
+func (s *Svc) errorPage(r *http.Request, st *errorPageState, status int) (string, string, bool) {
+	if st.keepBody {
+		return "", "", false
+	}
+
+	s.mutex.RLock()
+	global := s.errorPages
+	s.mutex.RUnlock()
+
+	var local *ErrorPages
+	if st.proxy != nil {
+		local = st.proxy.errorPages
+	}
+
+	if st.upstream {
+		// Backend responses are replaced only if asked to
+		intercept := local != nil && local.InterceptUpstream ||
+			local == nil && global != nil && global.InterceptUpstream
+		if !intercept || status < 500 {
+			return "", "", false
+		}
+	}
+
+	preferJSON := prefersJSON(r.Header.Get("Accept"))
+	page, contentType, ok := local.page(status, preferJSON)
+	if !ok {
+		page, contentType, ok = global.page(status, preferJSON)
+	}
+	if !ok {
+		return "", "", false
+	}
+
+	v := st.vars
+	if v == nil {
+		v = newRequestVars(r, st.proxy)
+	}
+
+	escape := html.EscapeString
+	if strings.HasPrefix(contentType, "application/json") {
+		escape = jsonEscape
+	}
+
+	extra := map[string]string{
+		"status":      strconv.Itoa(status),
+		"status_text": http.StatusText(status),
+	}
+	return v.expandWith(page, extra, escape), contentType, true
+}
+
+// prefersJSON tells if JSON has higher quality than HTML in Accept header
+func prefersJSON(accept string) bool {
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
+
+// acceptQuality returns quality of the value in Accept-like header,
+// 0 is returned for the values not listed
+func acceptQuality(header, value string) float64 {
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), value) {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		return q
+	}
+	return 0
+}
+
+// jsonEscape escapes string to be used inside JSON string
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// errorPageWriter replaces body of error responses with error page
+type errorPageWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	svc   *Svc
+	state *errorPageState
+
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *errorPageWriter) WriteHeader(status int) {
+	// Informational responses are followed by the final one
+	if w.wroteHeader || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	if status >= 400 {
+		if page, contentType, ok := w.svc.errorPage(w.r, w.state, status); ok {
+			h := w.Header()
+			h.Del("Content-Encoding")
+			h.Del("Transfer-Encoding")
+			h.Set("Content-Type", contentType)
+			h.Set("Content-Length", strconv.Itoa(len(page)))
+
+			w.ResponseWriter.WriteHeader(status)
+			io.WriteString(w.ResponseWriter, page)
+			w.replaced = true
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write discards the original body of replaced responses
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (w *errorPageWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if ok {
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("Hijacker is not implemented in underlying ResponseWriter")
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// denyAuth rejects all the requests like BasicAuth does
+type denyAuth struct{}
+
+func (denyAuth) IsAuthenticated(r *http.Request) bool { return false }
+
+func (denyAuth) Authenticate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Restricted area"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+type ErrorPagesTestSuite struct {
+	suite.Suite
+}
+
+func (s *ErrorPagesTestSuite) TestUnknownHost() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+	s.Require().NoError(svc.SetErrorPages(&ErrorPages{
+		HTML: map[int]string{0: "<p>$status $status_text: $host</p>"},
+		JSON: map[int]string{404: `{"error": "$status_text", "host": "$host"}`},
+	}))
+
+	h := svc.ErrorPagesHandler(svc)
+
+	r := httptest.NewRequest("GET", "http://<b>/", nil)
+	r.Header.Set("Accept", "text/html,application/json;q=0.9")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("<p>404 Not Found: &lt;b&gt;</p>", w.Body.String())
+
+	r = httptest.NewRequest("GET", "http://\"test\"/", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Equal("application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal(`{"error": "Not Found", "host": "\"test\""}`, w.Body.String())
+}
+
+func (s *ErrorPagesTestSuite) TestServicePages() {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "backend not found", http.StatusNotFound)
+		case "/failed":
+			http.Error(w, "backend failed", http.StatusInternalServerError)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer backend.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+	s.Require().NoError(svc.SetErrorPages(&ErrorPages{
+		HTML: map[int]string{0: "global $status"},
+	}))
+
+	b, err := NewBackend(backend.URL, nil)
+	s.Require().NoError(err)
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(p.SetErrorPages(&ErrorPages{
+		HTML:              map[int]string{500: "service $status $request_id"},
+		InterceptUpstream: true,
+	}))
+	s.Require().NoError(svc.AddProxy(p))
+
+	b2, err := NewBackend("http://127.0.0.1:1", nil)
+	s.Require().NoError(err)
+	p2, err := NewProxy(&Frontend{FQDN: "down.local"}, b2, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(svc.AddProxy(p2))
+
+	h := svc.ErrorPagesHandler(svc)
+	serve := func(url string) (int, string) {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("X-Request-Id", "test-id")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		body, err := ioutil.ReadAll(w.Result().Body)
+		s.Require().NoError(err)
+		return w.Code, string(body)
+	}
+
+	code, body := serve("http://test.local/")
+	s.Equal(http.StatusOK, code)
+	s.Equal("ok", body)
+
+	// Upstream 4xx responses are passed as is
+	code, body = serve("http://test.local/missing")
+	s.Equal(http.StatusNotFound, code)
+	s.Equal("backend not found\n", body)
+
+	code, body = serve("http://test.local/failed")
+	s.Equal(http.StatusInternalServerError, code)
+	s.Equal("service 500 test-id", body)
+
+	// Proxy errors are replaced by global pages
+	code, body = serve("http://down.local/")
+	s.Equal(http.StatusBadGateway, code)
+	s.Equal("global 502", body)
+}
+
+func (s *ErrorPagesTestSuite) TestAuthentication() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	b, err := NewBackend("http://127.0.0.1:1", nil)
+	s.Require().NoError(err)
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, denyAuth{}, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(p.SetErrorPages(&ErrorPages{
+		JSON: map[int]string{401: `{"status": $status}`},
+	}))
+	s.Require().NoError(svc.AddProxy(p))
+
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	w := httptest.NewRecorder()
+	svc.ErrorPagesHandler(svc).ServeHTTP(w, r)
+	s.Equal(http.StatusUnauthorized, w.Code)
+	s.NotEmpty(w.Header().Get("WWW-Authenticate"))
+	s.Equal(`{"status": 401}`, w.Body.String())
+}
+
+func (s *ErrorPagesTestSuite) TestValidation() {
+	s.Error((&ErrorPages{HTML: map[int]string{200: "ok"}}).Validate())
+	s.Error((&ErrorPages{JSON: map[int]string{0: "$unknown"}}).Validate())
+	s.NoError((&ErrorPages{HTML: map[int]string{503: "$status $$ $client_ip"}}).Validate())
+}
+
+func (s *ErrorPagesTestSuite) TestPrefersJSON() {
+	s.False(prefersJSON(""))
+	s.False(prefersJSON("*/*"))
+	s.False(prefersJSON("text/html,application/json"))
+	s.True(prefersJSON("application/json"))
+	s.True(prefersJSON("text/html;q=0.5, application/json"))
+}
+
+func TestErrorPagesTestSuite(t *testing.T) {
+	suite.Run(t, new(ErrorPagesTestSuite))
+}
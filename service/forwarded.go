@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/teran/svcproxy/middleware/types"
+)
+
+// Forwarding headers policies
+const (
+	// ForwardingXForwarded passes X-Forwarded-For, X-Forwarded-Proto,
+	// X-Forwarded-Host and X-Real-IP headers
+	ForwardingXForwarded = "xforwarded"
+	// ForwardingForwarded passes RFC 7239 Forwarded header
+	ForwardingForwarded = "forwarded"
+	// ForwardingBoth passes both of X-Forwarded-* and Forwarded headers
+	ForwardingBoth = "both"
+	// ForwardingNone removes forwarding headers
+	ForwardingNone = "none"
+)
+
+// forwardingHeaders are the headers set by the forwarding policy,
+// their values received from the client are never passed as is
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Host",
+	"X-Real-IP",
+}
+
+// Forwarding resolves the client of requests passed through trusted
+// proxies and defines forwarding headers passed to backends
+type Forwarding struct {
+	trustedProxies []*net.IPNet
+	headers        string
+}
+
+type forwardingKey struct{}
+
+// NewForwarding creates new Forwarding instance. Trusted proxies are
+// CIDRs or single addresses, forwarding headers of requests from other
+// peers are ignored.
+func NewForwarding(trustedProxies []string, headers string) (*Forwarding, error) {
+	switch headers {
+	case "":
+		headers = ForwardingXForwarded
+	case ForwardingXForwarded, ForwardingForwarded, ForwardingBoth, ForwardingNone:
+	default:
+		return nil, fmt.Errorf("unknown forwarding headers policy: `%s`", headers)
+	}
+
+	f := &Forwarding{headers: headers}
+	for _, tp := range trustedProxies {
+		if !strings.Contains(tp, "/") {
+			ip := net.ParseIP(tp)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: `%s`", tp)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			f.trustedProxies = append(f.trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(tp)
+		if err != nil {
+			return nil, err
+		}
+		f.trustedProxies = append(f.trustedProxies, n)
+	}
+	return f, nil
+}
+
+// Handler resolves the client of the request before passing it
+// to the next handler
+func (f *Forwarding) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := types.WithClient(r.Context(), f.resolve(r))
+		ctx = context.WithValue(ctx, forwardingKey{}, f)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// resolve determines the client of the request. Forwarding headers are
+// taken into account only if the peer is trusted. The client is the
+// rightmost address of the chain which is not a trusted proxy.
+func (f *Forwarding) resolve(r *http.Request) *types.Client {
+	peer, _, _ := net.SplitHostPort(r.RemoteAddr)
+	c := &types.Client{
+		IP:     peer,
+		PeerIP: peer,
+		Proto:  "http",
+		Host:   r.Host,
+	}
+	if r.TLS != nil {
+		c.Proto = "https"
+	}
+
+	if !f.isTrusted(peer) {
+		return c
+	}
+
+	c.ForwardedFor = splitHeaderList(r.Header["X-Forwarded-For"])
+	c.Forwarded = splitHeaderList(r.Header["Forwarded"])
+
+	var chain []string
+	var proto, host string
+	if len(c.Forwarded) > 0 {
+		for i, e := range c.Forwarded {
+			params := parseForwardedElement(e)
+			chain = append(chain, nodeIP(params["for"]))
+			if i == 0 {
+				proto, host = params["proto"], params["host"]
+			}
+		}
+		if len(c.ForwardedFor) == 0 {
+			c.ForwardedFor = chain
+		}
+	} else {
+		chain = c.ForwardedFor
+		proto = firstHeaderValue(r.Header.Get("X-Forwarded-Proto"))
+		host = firstHeaderValue(r.Header.Get("X-Forwarded-Host"))
+		for _, ip := range chain {
+			c.Forwarded = append(c.Forwarded, "for="+forwardedValue(forwardedNode(ip)))
+		}
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			break
+		}
+		c.IP = chain[i]
+		if !f.isTrusted(chain[i]) {
+			break
+		}
+	}
+
+	switch strings.ToLower(proto) {
+	case "http", "https":
+		c.Proto = strings.ToLower(proto)
+	}
+	if host != "" {
+		c.Host = host
+	}
+	return c
+}
+
+func (f *Forwarding) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range f.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientFromRequest returns the client resolved by Forwarding handler,
+// peer is considered to be the client if request wasn't passed through it
+func clientFromRequest(r *http.Request) *types.Client {
+	if c := types.ClientFromContext(r.Context()); c != nil {
+		return c
+	}
+	return (&Forwarding{}).resolve(r)
+}
+
+// forwardingHeadersPolicy returns forwarding headers policy of the request
+func forwardingHeadersPolicy(r *http.Request) string {
+	if f, ok := r.Context().Value(forwardingKey{}).(*Forwarding); ok {
+		return f.headers
+	}
+	return ForwardingXForwarded
+}
+
+// setForwardingHeaders sets forwarding headers of the request passed to
+// the backend. X-Forwarded-For is left with the chain received from trusted
+// peer since ReverseProxy appends peer address to it.
+func setForwardingHeaders(r *http.Request) {
+	c := clientFromRequest(r)
+	policy := forwardingHeadersPolicy(r)
+
+	for _, h := range forwardingHeaders {
+		r.Header.Del(h)
+	}
+
+	if policy == ForwardingXForwarded || policy == ForwardingBoth {
+		if len(c.ForwardedFor) > 0 {
+			r.Header.Set("X-Forwarded-For", strings.Join(c.ForwardedFor, ", "))
+		}
+		r.Header.Set("X-Real-IP", clientIP(r))
+		r.Header.Set("X-Forwarded-Proto", c.Proto)
+		r.Header.Set("X-Forwarded-Host", c.Host)
+	} else {
+		// nil value prevents ReverseProxy from adding the header
+		r.Header["X-Forwarded-For"] = nil
+	}
+
+	if policy == ForwardingForwarded || policy == ForwardingBoth {
+		node := "unknown"
+		if c.PeerIP != "" {
+			node = forwardedValue(forwardedNode(c.PeerIP))
+		}
+		element := "for=" + node + ";proto=" + c.Proto
+		if c.Host != "" {
+			element += ";host=" + forwardedValue(c.Host)
+		}
+
+		elements := append([]string{}, c.Forwarded...)
+		r.Header.Set("Forwarded", strings.Join(append(elements, element), ", "))
+	}
+}
+
+// addPeerForwardedFor appends peer address to X-Forwarded-For the same way
+// ReverseProxy does for the requests sent without it
+func addPeerForwardedFor(r *http.Request) {
+	prior, ok := r.Header["X-Forwarded-For"]
+	if ok && prior == nil {
+		delete(r.Header, "X-Forwarded-For")
+		return
+	}
+
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return
+	}
+	if len(prior) > 0 {
+		peer = strings.Join(prior, ", ") + ", " + peer
+	}
+	r.Header.Set("X-Forwarded-For", peer)
+}
+
+// splitHeaderList splits comma separated header values
+func splitHeaderList(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+func firstHeaderValue(v string) string {
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// parseForwardedElement parses RFC 7239 forwarded-element like
+// `for=192.0.2.1;proto=https` to the map of lowercased parameter names
+func parseForwardedElement(e string) map[string]string {
+	params := make(map[string]string)
+	for _, pair := range strings.Split(e, ";") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(pair[:i]))
+		value := strings.TrimSpace(pair[i+1:])
+		if len(value) > 1 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = strings.Replace(value[1:len(value)-1], `\`, "", -1)
+		}
+		params[name] = value
+	}
+	return params
+}
+
+// nodeIP returns IP address of RFC 7239 node like `[2001:db8::1]:8080`,
+// obfuscated and unknown nodes are returned as is
+func nodeIP(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
+
+// forwardedNode formats IP address as RFC 7239 node
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// forwardedValue quotes the value if it's not a valid token
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/teran/svcproxy/middleware/types"
+)
+
+type ForwardedTestSuite struct {
+	suite.Suite
+}
+
+func (s *ForwardedTestSuite) TestResolve() {
+	f, err := NewForwarding([]string{"10.0.0.0/8", "2001:db8::1"}, "")
+	s.Require().NoError(err)
+
+	tcs := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		ip      string
+		proto   string
+		host    string
+	}{
+		{
+			name:    "untrusted peer",
+			peer:    "192.0.2.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.1", "X-Forwarded-Proto": "https"},
+			ip:      "192.0.2.1",
+			proto:   "http",
+			host:    "test.local",
+		},
+		{
+			name: "trusted peer",
+			peer: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1, 203.0.113.1, 10.0.0.2",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "public.local",
+			},
+			ip:    "203.0.113.1",
+			proto: "https",
+			host:  "public.local",
+		},
+		{
+			name:    "all addresses are trusted",
+			peer:    "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			ip:      "10.0.0.3",
+			proto:   "http",
+			host:    "test.local",
+		},
+		{
+			name:    "invalid address in chain",
+			peer:    "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "unknown, 10.0.0.2"},
+			ip:      "10.0.0.2",
+			proto:   "http",
+			host:    "test.local",
+		},
+		{
+			name:    "forwarded header",
+			peer:    "[2001:db8::1]:1234",
+			headers: map[string]string{"Forwarded": `for="[2001:db8::2]:4711";proto=https;host=public.local, for=10.0.0.2`},
+			ip:      "2001:db8::2",
+			proto:   "https",
+			host:    "public.local",
+		},
+	}
+
+	for _, tc := range tcs {
+		r := httptest.NewRequest("GET", "http://test.local/", nil)
+		r.RemoteAddr = tc.peer
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+
+		c := f.resolve(r)
+		s.Equal(tc.ip, c.IP, tc.name)
+		s.Equal(tc.proto, c.Proto, tc.name)
+		s.Equal(tc.host, c.Host, tc.name)
+	}
+}
+
+func (s *ForwardedTestSuite) TestProxy() {
+	received := make(chan http.Header, 1)
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer testsrv.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	b, err := NewBackend(testsrv.URL, nil)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(svc.AddProxy(p))
+
+	serve := func(headers string, peer string, h map[string]string) http.Header {
+		f, err := NewForwarding([]string{"10.0.0.0/8"}, headers)
+		s.Require().NoError(err)
+
+		r := httptest.NewRequest("GET", "http://test.local/", nil)
+		r.RemoteAddr = peer
+		for k, v := range h {
+			r.Header.Set(k, v)
+		}
+
+		var client *types.Client
+		handler := f.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client = types.ClientFromContext(r.Context())
+			s.Equal(client.IP, types.ClientIP(r))
+			svc.ServeHTTP(w, r)
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		s.Equal(http.StatusNoContent, w.Result().StatusCode)
+		s.NotNil(client)
+		return <-received
+	}
+
+	h := serve(ForwardingBoth, "10.0.0.1:1234", map[string]string{
+		"X-Forwarded-For":   "203.0.113.1",
+		"X-Forwarded-Proto": "https",
+	})
+	s.Equal("203.0.113.1, 10.0.0.1", h.Get("X-Forwarded-For"))
+	s.Equal("203.0.113.1", h.Get("X-Real-IP"))
+	s.Equal("https", h.Get("X-Forwarded-Proto"))
+	s.Equal("test.local", h.Get("X-Forwarded-Host"))
+	s.Equal("for=203.0.113.1, for=10.0.0.1;proto=https;host=test.local", h.Get("Forwarded"))
+
+	// Headers passed by untrusted peer are dropped
+	h = serve(ForwardingXForwarded, "192.0.2.1:1234", map[string]string{
+		"X-Forwarded-For":  "203.0.113.1",
+		"X-Forwarded-Host": "spoofed.local",
+		"Forwarded":        "for=203.0.113.1",
+	})
+	s.Equal("192.0.2.1", h.Get("X-Forwarded-For"))
+	s.Equal("192.0.2.1", h.Get("X-Real-IP"))
+	s.Equal("http", h.Get("X-Forwarded-Proto"))
+	s.Equal("test.local", h.Get("X-Forwarded-Host"))
+	s.Equal("", h.Get("Forwarded"))
+
+	h = serve(ForwardingForwarded, "[2001:db8::1]:1234", nil)
+	s.Equal(`for="[2001:db8::1]";proto=http;host=test.local`, h.Get("Forwarded"))
+	s.NotContains(h, "X-Forwarded-For")
+	s.NotContains(h, "X-Real-Ip")
+
+	h = serve(ForwardingNone, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.1"})
+	for _, name := range forwardingHeaders {
+		s.NotContains(h, http.CanonicalHeaderKey(name))
+	}
+}
+
+func (s *ForwardedTestSuite) TestNewForwardingValidation() {
+	_, err := NewForwarding([]string{"10.0.0.0/33"}, "")
+	s.Error(err)
+
+	_, err = NewForwarding([]string{"not an address"}, "")
+	s.Error(err)
+
+	_, err = NewForwarding(nil, "unknown")
+	s.Error(err)
+}
+
+func TestForwardedTestSuite(t *testing.T) {
+	suite.Run(t, new(ForwardedTestSuite))
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+)
+
+// NewBackendGroup creates new BackendGroup instance
+func NewBackendGroup(name string, weight int, backend *Backend, transport http.RoundTripper, logger *log.Logger) (*BackendGroup, error) {
+	if name == "" {
+		return nil, fmt.Errorf("backend group name is required")
+	}
+	if weight < 0 || weight > 100 {
+		return nil, fmt.Errorf("backend group weight must be percentage between 0 and 100: %d", weight)
+	}
+
+	g := &BackendGroup{
+		Name:    name,
+		Weight:  weight,
+		Backend: backend,
+		proxy:   NewReverseProxy(backend, transport),
+	}
+	if logger != nil {
+		g.proxy.ErrorLog = logger
+	}
+	return g, nil
+}
+
+// GroupOverride pins requests to the backend group by header, cookie
+// or query parameter value. Exactly one of Header, Cookie and Query
+// must be set, empty Value matches any non-empty one.
+type GroupOverride struct {
+	Header string
+	Cookie string
+	Query  string
+	Value  string
+	Group  string
+}
+
+type groupOverride struct {
+	GroupOverride
+	group *BackendGroup
+}
+
+// SetBackendGroups splits proxy traffic between backend groups by their
+// weights, the weights must sum up to 100. Overrides are checked in order
+// they're passed before weighted choice is made.
+func (p *Proxy) SetBackendGroups(groups []*BackendGroup, overrides []GroupOverride) error {
+	byName := make(map[string]*BackendGroup)
+	total := 0
+	for _, g := range groups {
+		if _, ok := byName[g.Name]; ok {
+			return fmt.Errorf("duplicate backend group `%s`", g.Name)
+		}
+		byName[g.Name] = g
+		total += g.Weight
+	}
+	if len(groups) > 0 && total != 100 {
+		return fmt.Errorf("backend group weights must sum up to 100, got %d", total)
+	}
+
+	var resolved []groupOverride
+	for _, o := range overrides {
+		set := 0
+		for _, v := range []string{o.Header, o.Cookie, o.Query} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("exactly one of header, cookie or query is required for group override")
+		}
+
+		g, ok := byName[o.Group]
+		if !ok {
+			return fmt.Errorf("unknown backend group `%s` in override", o.Group)
+		}
+		resolved = append(resolved, groupOverride{GroupOverride: o, group: g})
+	}
+
+	p.Groups = groups
+	p.overrides = resolved
+	return nil
+}
+
+// group chooses backend group for the request, nil if proxy has no groups
+func (p *Proxy) group(r *http.Request) *BackendGroup {
+	if len(p.Groups) == 0 {
+		return nil
+	}
+
+	for _, o := range p.overrides {
+		if o.matches(r) {
+			return o.group
+		}
+	}
+
+	n := rand.Intn(100)
+	for _, g := range p.Groups {
+		if n < g.Weight {
+			return g
+		}
+		n -= g.Weight
+	}
+	return p.Groups[len(p.Groups)-1]
+}
+
+func (o *groupOverride) matches(r *http.Request) bool {
+	var value string
+	switch {
+	case o.Header != "":
+		value = r.Header.Get(o.Header)
+	case o.Cookie != "":
+		if c, err := r.Cookie(o.Cookie); err == nil {
+			value = c.Value
+		}
+	case o.Query != "":
+		value = r.URL.Query().Get(o.Query)
+	}
+
+	if o.Value == "" {
+		return value != ""
+	}
+	return value == o.Value
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/teran/svcproxy/middleware/types"
+)
+
+type GroupTestSuite struct {
+	suite.Suite
+}
+
+func (s *GroupTestSuite) newGroup(name string, weight int, srv *httptest.Server) *BackendGroup {
+	b, err := NewBackend(srv.URL, nil)
+	s.Require().NoError(err)
+
+	g, err := NewBackendGroup(name, weight, b, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	return g
+}
+
+func (s *GroupTestSuite) newServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Group", name)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func (s *GroupTestSuite) TestGroups() {
+	stableSrv := s.newServer("stable")
+	defer stableSrv.Close()
+	canarySrv := s.newServer("canary")
+	defer canarySrv.Close()
+
+	stable := s.newGroup("stable", 100, stableSrv)
+	canary := s.newGroup("canary", 0, canarySrv)
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, stable.Backend, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	err = p.SetBackendGroups([]*BackendGroup{stable, canary}, []GroupOverride{
+		{Header: "X-Canary", Value: "1", Group: "canary"},
+		{Cookie: "canary", Group: "canary"},
+		{Query: "group", Value: "canary", Group: "canary"},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(svc.AddProxy(p))
+
+	serve := func(r *http.Request) (string, string) {
+		stats := &types.RequestStats{}
+		r = r.WithContext(types.WithRequestStats(r.Context(), stats))
+
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, r)
+		return w.Result().Header.Get("X-Group"), stats.BackendGroup()
+	}
+
+	for i := 0; i < 10; i++ {
+		group, reported := serve(httptest.NewRequest("GET", "http://test.local/", nil))
+		s.Equal("stable", group)
+		s.Equal("stable", reported)
+	}
+
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	r.Header.Set("X-Canary", "1")
+	group, reported := serve(r)
+	s.Equal("canary", group)
+	s.Equal("canary", reported)
+
+	r = httptest.NewRequest("GET", "http://test.local/", nil)
+	r.Header.Set("X-Canary", "0")
+	group, _ = serve(r)
+	s.Equal("stable", group)
+
+	r = httptest.NewRequest("GET", "http://test.local/", nil)
+	r.AddCookie(&http.Cookie{Name: "canary", Value: "yes"})
+	group, _ = serve(r)
+	s.Equal("canary", group)
+
+	group, _ = serve(httptest.NewRequest("GET", "http://test.local/?group=canary", nil))
+	s.Equal("canary", group)
+}
+
+func (s *GroupTestSuite) TestWeights() {
+	aSrv := s.newServer("a")
+	defer aSrv.Close()
+	bSrv := s.newServer("b")
+	defer bSrv.Close()
+
+	a := s.newGroup("a", 50, aSrv)
+	b := s.newGroup("b", 50, bSrv)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, a.Backend, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(p.SetBackendGroups([]*BackendGroup{a, b}, nil))
+
+	hits := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		hits[p.group(httptest.NewRequest("GET", "http://test.local/", nil)).Name]++
+	}
+	s.InDelta(500, hits["a"], 100)
+	s.InDelta(500, hits["b"], 100)
+}
+
+func (s *GroupTestSuite) TestValidation() {
+	srv := s.newServer("a")
+	defer srv.Close()
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, nil, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	_, err = NewBackendGroup("", 100, nil, http.DefaultTransport, nil)
+	s.Error(err)
+
+	_, err = NewBackendGroup("a", 101, nil, http.DefaultTransport, nil)
+	s.Error(err)
+
+	s.Error(p.SetBackendGroups([]*BackendGroup{s.newGroup("a", 50, srv)}, nil))
+	s.Error(p.SetBackendGroups([]*BackendGroup{s.newGroup("a", 50, srv), s.newGroup("a", 50, srv)}, nil))
+	s.Error(p.SetBackendGroups([]*BackendGroup{s.newGroup("a", 100, srv)}, []GroupOverride{{Header: "X-Canary", Group: "unknown"}}))
+	s.Error(p.SetBackendGroups([]*BackendGroup{s.newGroup("a", 100, srv)}, []GroupOverride{{Header: "X-Canary", Cookie: "canary", Group: "a"}}))
+	s.Error(p.SetBackendGroups([]*BackendGroup{s.newGroup("a", 100, srv)}, []GroupOverride{{Group: "a"}}))
+}
+
+func TestGroupTestSuite(t *testing.T) {
+	suite.Run(t, new(GroupTestSuite))
+}
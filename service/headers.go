@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/teran/svcproxy/authentication"
+)
+
+// Header rule actions
+const (
+	HeaderActionSet    = "set"
+	HeaderActionAppend = "append"
+	HeaderActionRemove = "remove"
+)
+
+// HeaderRule defines modification of HTTP header. Value could refer
+// to request variables like `$client_ip` or `${request_id}`, `$$` stands
+// for the dollar sign itself.
+//
+// Supported variables:
+//
+//	client_ip   - address of the client
+//	request_id  - X-Request-Id passed by client or generated one
+//	tls_version - TLS version of client connection, e.g. `TLSv1.2`
+//	sni         - server name requested by client during TLS handshake
+//	user        - name of authenticated user
+//	service     - name of the service handling request
+//	scheme      - scheme of client request
+//	host        - host of client request
+//	time        - request time in RFC3339 format
+//	time_unix   - request time as Unix timestamp
+type HeaderRule struct {
+	Name  string
+	Value string
+	// Action is `set` replacing existing values, `append` adding one
+	// more value or `remove` deleting the header, `set` is the default
+	Action string
+}
+
+// SetRequestHeaders sets header rules applied to requests passed
+// to the backend after static request headers
+func (b *Backend) SetRequestHeaders(rules []HeaderRule) error {
+	if err := validateHeaderRules(rules); err != nil {
+		return err
+	}
+
+	b.requestHeaders = rules
+	return nil
+}
+
+// SetResponseHeaders sets header rules applied to responses received
+// from the backends after static response headers
+func (f *Frontend) SetResponseHeaders(rules []HeaderRule) error {
+	if err := validateHeaderRules(rules); err != nil {
+		return err
+	}
+
+	f.responseHeaders = rules
+	return nil
+}
+
+func validateHeaderRules(rules []HeaderRule) error {
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("header name is required for header rule")
+		}
+
+		switch rule.Action {
+		case "":
+			rules[i].Action = HeaderActionSet
+		case HeaderActionSet, HeaderActionAppend, HeaderActionRemove:
+		default:
+			return fmt.Errorf("unknown action for header `%s`: `%s`", rule.Name, rule.Action)
+		}
+
+		if unknown := unknownVariable(rule.Value, nil); unknown != "" {
+			return fmt.Errorf("unknown variable in header `%s`: `%s`", rule.Name, unknown)
+		}
+	}
+	return nil
+}
+
+// unknownVariable returns the first variable of the string which is neither
+// request variable nor one of extra variables
+func unknownVariable(s string, extra map[string]string) string {
+	var unknown string
+	os.Expand(s, func(name string) string {
+		_, isExtra := extra[name]
+		if _, ok := headerVariables[name]; !ok && !isExtra && name != "$" && unknown == "" {
+			unknown = name
+		}
+		return ""
+	})
+	return unknown
+}
+
+// headerVariables maps variable names to their values
+var headerVariables = map[string]func(*requestVars) string{
+	"client_ip":   func(v *requestVars) string { return v.clientIP },
+	"request_id":  (*requestVars).requestID,
+	"tls_version": func(v *requestVars) string { return v.tlsVersion },
+	"sni":         func(v *requestVars) string { return v.sni },
+	"user":        func(v *requestVars) string { return v.user },
+	"service":     func(v *requestVars) string { return v.service },
+	"scheme":      func(v *requestVars) string { return v.scheme },
+	"host":        func(v *requestVars) string { return v.host },
+	"time":        func(v *requestVars) string { return v.time.Format(time.RFC3339) },
+	"time_unix":   func(v *requestVars) string { return strconv.FormatInt(v.time.Unix(), 10) },
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+type requestVarsKey struct{}
+
+// requestVars are per request values available to header rules
+type requestVars struct {
+	frontend   *Frontend
+	clientIP   string
+	tlsVersion string
+	sni        string
+	user       string
+	service    string
+	scheme     string
+	host       string
+	time       time.Time
+
+	requestIDOnce sync.Once
+	id            string
+}
+
+// newRequestVars collects variables of the request handled by proxy
+func newRequestVars(r *http.Request, p *Proxy) *requestVars {
+	c := clientFromRequest(r)
+	v := &requestVars{
+		clientIP: clientIP(r),
+		scheme:   c.Proto,
+		host:     c.Host,
+		time:     time.Now(),
+		id:       r.Header.Get("X-Request-Id"),
+	}
+
+	if r.TLS != nil {
+		v.tlsVersion = tlsVersions[r.TLS.Version]
+		v.sni = r.TLS.ServerName
+	}
+
+	if p != nil {
+		v.frontend = p.Frontend
+		v.service = p.Service
+		if ui, ok := p.Authenticator.(authentication.UserIdentifier); ok {
+			v.user = ui.User(r)
+		}
+	}
+	return v
+}
+
+// withRequestVars returns request carrying variables for header rules
+func withRequestVars(r *http.Request, p *Proxy) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestVarsKey{}, newRequestVars(r, p)))
+}
+
+// requestVarsFromRequest returns variables of the request, variables
+// are collected from the request itself if it wasn't passed through
+// the service
+func requestVarsFromRequest(r *http.Request) *requestVars {
+	if v, ok := r.Context().Value(requestVarsKey{}).(*requestVars); ok {
+		return v
+	}
+	return newRequestVars(r, nil)
+}
+
+// requestID returns request ID passed by client or generates new one
+func (v *requestVars) requestID() string {
+	v.requestIDOnce.Do(func() {
+		if v.id != "" {
+			return
+		}
+
+		b := make([]byte, 16)
+		rand.Read(b)
+		v.id = hex.EncodeToString(b)
+	})
+	return v.id
+}
+
+func (v *requestVars) expand(s string) string {
+	return v.expandWith(s, nil, nil)
+}
+
+// expandWith substitutes request and extra variables, values of
+// the variables are escaped if escape function is passed
+func (v *requestVars) expandWith(s string, extra map[string]string, escape func(string) string) string {
+	if escape == nil {
+		escape = func(s string) string { return s }
+	}
+
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if value, ok := extra[name]; ok {
+			return escape(value)
+		}
+		if f, ok := headerVariables[name]; ok {
+			return escape(f(v))
+		}
+		return ""
+	})
+}
+
+// applyHeaderRules modifies headers according to the rules
+func applyHeaderRules(h http.Header, rules []HeaderRule, v *requestVars) {
+	for _, rule := range rules {
+		switch rule.Action {
+		case HeaderActionRemove:
+			h.Del(rule.Name)
+		case HeaderActionAppend:
+			h.Add(rule.Name, v.expand(rule.Value))
+		default:
+			h.Set(rule.Name, v.expand(rule.Value))
+		}
+	}
+}
+
+// applyResponseHeaders sets frontend response headers of the request
+func applyResponseHeaders(h http.Header, r *http.Request) {
+	v := requestVarsFromRequest(r)
+	if v.frontend == nil {
+		return
+	}
+
+	for k, val := range v.frontend.ResponseHTTPHeaders {
+		h.Set(k, val)
+	}
+	applyHeaderRules(h, v.frontend.responseHeaders, v)
+}
+
+// clientIP returns address of the client sent the request
+func clientIP(r *http.Request) string {
+	remoteIP := clientFromRequest(r).IP
+	if remoteIP == "" {
+		remoteIP = "0.0.0.0"
+	}
+	return remoteIP
+}
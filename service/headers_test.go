@@ -0,0 +1,149 @@
+package service
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HeadersTestSuite struct {
+	suite.Suite
+}
+
+func (s *HeadersTestSuite) TestApplyHeaderRules() {
+	rules := []HeaderRule{
+		{Name: "X-Remove", Action: HeaderActionRemove},
+		{Name: "X-Set", Value: "$client_ip ${tls_version} $sni $service $user"},
+		{Name: "X-Append", Value: "$scheme://$host", Action: HeaderActionAppend},
+		{Name: "X-Time", Value: "$time_unix $time"},
+		{Name: "X-Literal", Value: "$$1"},
+	}
+	s.Require().NoError(validateHeaderRules(rules))
+
+	r := httptest.NewRequest("GET", "https://test.local/", nil)
+	r.RemoteAddr = "192.0.2.1:12345"
+	r.TLS = &tls.ConnectionState{Version: tls.VersionTLS12, ServerName: "test.local"}
+
+	v := newRequestVars(r, &Proxy{Service: "test", Frontend: &Frontend{FQDN: "test.local"}})
+	v.user = "gotest"
+	v.time = time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h := http.Header{
+		"X-Remove": {"1"},
+		"X-Set":    {"1", "2"},
+		"X-Append": {"1"},
+	}
+	applyHeaderRules(h, rules, v)
+
+	s.Equal(http.Header{
+		"X-Set":     {"192.0.2.1 TLSv1.2 test.local test gotest"},
+		"X-Append":  {"1", "https://test.local"},
+		"X-Time":    {"1514862245 2018-01-02T03:04:05Z"},
+		"X-Literal": {"$1"},
+	}, h)
+}
+
+func (s *HeadersTestSuite) TestRequestID() {
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	v := newRequestVars(r, nil)
+	s.Len(v.requestID(), 32)
+	s.Equal(v.requestID(), v.requestID())
+
+	r.Header.Set("X-Request-Id", "client-id")
+	s.Equal("client-id", newRequestVars(r, nil).requestID())
+}
+
+func (s *HeadersTestSuite) TestProxy() {
+	received := make(chan *http.Request, 1)
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		w.Header().Set("Server", "backend")
+		w.Header().Set("Strict-Transport-Security", "max-age=0")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer testsrv.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	b, err := NewBackend(testsrv.URL, map[string]string{"X-Static": "yes"})
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetRequestHeaders([]HeaderRule{
+		{Name: "Authorization", Action: HeaderActionRemove},
+		{Name: "X-Request-Id", Value: "$request_id"},
+		{Name: "X-Service", Value: "$service"},
+	}))
+
+	f, err := NewFrontend("test.local", "proxy", map[string]string{"Strict-Transport-Security": "max-age=31536000"})
+	s.Require().NoError(err)
+	s.Require().NoError(f.SetResponseHeaders([]HeaderRule{
+		{Name: "Server", Action: HeaderActionRemove},
+		{Name: "Cache-Control", Value: "private", Action: HeaderActionAppend},
+		{Name: "X-Request-Id", Value: "$request_id"},
+	}))
+
+	p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	p.Service = "test"
+	s.Require().NoError(svc.AddProxy(p))
+
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	r.SetBasicAuth("user", "password")
+	w := httptest.NewRecorder()
+	svc.ServeHTTP(w, r)
+
+	resp := w.Result()
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+
+	br := <-received
+	s.Equal("", br.Header.Get("Authorization"))
+	s.Equal("yes", br.Header.Get("X-Static"))
+	s.Equal("test", br.Header.Get("X-Service"))
+	s.Len(br.Header.Get("X-Request-Id"), 32)
+
+	s.Equal(br.Header.Get("X-Request-Id"), resp.Header.Get("X-Request-Id"))
+	s.Equal("", resp.Header.Get("Server"))
+	s.Equal([]string{"max-age=31536000"}, resp.Header["Strict-Transport-Security"])
+	s.Equal([]string{"no-cache", "private"}, resp.Header["Cache-Control"])
+}
+
+func (s *HeadersTestSuite) TestGatewayError() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	b, err := NewBackend("http://127.0.0.1:1", nil)
+	s.Require().NoError(err)
+
+	f, err := NewFrontend("test.local", "proxy", nil)
+	s.Require().NoError(err)
+	s.Require().NoError(f.SetResponseHeaders([]HeaderRule{{Name: "X-Service", Value: "$service"}}))
+
+	p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(svc.AddProxy(p))
+
+	w := httptest.NewRecorder()
+	svc.ServeHTTP(w, httptest.NewRequest("GET", "http://test.local/", nil))
+
+	s.Equal(http.StatusBadGateway, w.Result().StatusCode)
+	s.Equal("test.local", w.Result().Header.Get("X-Service"))
+}
+
+func (s *HeadersTestSuite) TestValidation() {
+	s.Error(validateHeaderRules([]HeaderRule{{Value: "1"}}))
+	s.Error(validateHeaderRules([]HeaderRule{{Name: "X-Test", Action: "replace"}}))
+	s.Error(validateHeaderRules([]HeaderRule{{Name: "X-Test", Value: "$unknown"}}))
+
+	rules := []HeaderRule{{Name: "X-Test", Value: "1"}}
+	s.NoError(validateHeaderRules(rules))
+	s.Equal(HeaderActionSet, rules[0].Action)
+}
+
+func TestHeadersTestSuite(t *testing.T) {
+	suite.Run(t, new(HeadersTestSuite))
+}
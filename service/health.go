@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var targetHealthy = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "backend_target_healthy",
+		Help: "A gauge of backend target health state: 1 for healthy and 0 for unhealthy targets.",
+	},
+	[]string{"service", "target"},
+)
+
+// targetMetrics counts backends exposing metrics of the same service target,
+// series are deleted when the last of them is closed. Backends replaced on
+// configuration reload are closed after the new ones are set up.
+var targetMetrics = struct {
+	sync.Mutex
+	backends map[targetMetricsKey]int
+}{backends: make(map[targetMetricsKey]int)}
+
+type targetMetricsKey struct {
+	service string
+	target  string
+}
+
+func init() {
+	prometheus.MustRegister(targetHealthy)
+}
+
+// HealthCheck defines active health checking of backend targets
+type HealthCheck struct {
+	// Type of the check: "http" or "tcp"
+	Type string
+	// Path to request by HTTP checks
+	Path string
+	// Interval between the checks
+	Interval time.Duration
+	// Timeout for a single check
+	Timeout time.Duration
+	// HealthyThreshold is amount of consecutive successful checks
+	// to mark target healthy
+	HealthyThreshold int
+	// UnhealthyThreshold is amount of consecutive failed checks
+	// to mark target unhealthy
+	UnhealthyThreshold int
+	// ExpectedStatus is the list of HTTP status codes treated as success,
+	// any 2xx or 3xx status is treated as success if empty
+	ExpectedStatus []int
+}
+
+// Healthy returns target health state
+func (t *Target) Healthy() bool {
+	return atomic.LoadInt32(&t.unhealthy) == 0
+}
+
+func (t *Target) setHealthy(healthy bool) {
+	var unhealthy int32 = 1
+	if healthy {
+		unhealthy = 0
+	}
+
+	if atomic.SwapInt32(&t.unhealthy, unhealthy) != unhealthy {
+		log.WithFields(log.Fields{
+			"target":  t.URL.String(),
+			"healthy": healthy,
+		}).Warn("Target health state changed")
+	}
+
+	if healthy {
+		setTargetGauge(targetHealthy, t, 1)
+	} else {
+		setTargetGauge(targetHealthy, t, 0)
+	}
+}
+
+// SetHealthCheck starts active health checking of the backend targets.
+// Targets failing the checks are excluded from balancing until they recover.
+func (b *Backend) SetHealthCheck(hc *HealthCheck, transport http.RoundTripper) error {
+	if hc.Interval <= 0 {
+		return fmt.Errorf("health check interval must be positive")
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = hc.Interval
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = 1
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = 1
+	}
+
+	switch hc.Type {
+	case "", "http":
+		hc.Type = "http"
+	case "tcp":
+	default:
+		return fmt.Errorf("unknown health check type `%s`", hc.Type)
+	}
+
+	b.stopHealthChecking()
+	b.exposeMetrics()
+
+	b.healthCheck = hc
+	b.stopHealthChecks = make(chan struct{})
+	b.healthChecks = &sync.WaitGroup{}
+
+	if b.transport != nil {
+		transport = b.transport
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   hc.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, t := range b.Targets {
+		t.setHealthy(t.Healthy())
+
+		b.healthChecks.Add(1)
+		go func(t *Target) {
+			defer b.healthChecks.Done()
+			b.runHealthChecks(t, client, b.stopHealthChecks)
+		}(t)
+	}
+	return nil
+}
+
+// Close stops backend's background activities like health checking
+// and deletes metrics of its targets
+func (b *Backend) Close() error {
+	b.stopHealthChecking()
+	b.deleteMetrics()
+	return nil
+}
+
+func (b *Backend) stopHealthChecking() {
+	if b.stopHealthChecks != nil {
+		close(b.stopHealthChecks)
+		b.healthChecks.Wait()
+		b.stopHealthChecks = nil
+	}
+}
+
+// exposeMetrics registers the backend as exposing metrics of its targets
+func (b *Backend) exposeMetrics() {
+	if b.metrics {
+		return
+	}
+	b.metrics = true
+
+	targetMetrics.Lock()
+	defer targetMetrics.Unlock()
+
+	for _, t := range b.Targets {
+		targetMetrics.backends[targetMetricsKey{t.service, t.URL.String()}]++
+	}
+}
+
+// setTargetGauge sets the gauge of the target unless all the backends
+// exposing it are closed, e.g. by requests in flight completed later
+func setTargetGauge(g *prometheus.GaugeVec, t *Target, value float64) {
+	targetMetrics.Lock()
+	defer targetMetrics.Unlock()
+
+	key := targetMetricsKey{t.service, t.URL.String()}
+	if targetMetrics.backends[key] > 0 {
+		g.WithLabelValues(key.service, key.target).Set(value)
+	}
+}
+
+// deleteMetrics deletes metrics of the targets not exposed by other backends
+func (b *Backend) deleteMetrics() {
+	if !b.metrics {
+		return
+	}
+	b.metrics = false
+
+	targetMetrics.Lock()
+	defer targetMetrics.Unlock()
+
+	for _, t := range b.Targets {
+		key := targetMetricsKey{t.service, t.URL.String()}
+		if targetMetrics.backends[key]--; targetMetrics.backends[key] > 0 {
+			continue
+		}
+		delete(targetMetrics.backends, key)
+		targetHealthy.DeleteLabelValues(key.service, key.target)
+		targetCircuitState.DeleteLabelValues(key.service, key.target)
+	}
+}
+
+// available returns targets requests could be passed to
+func (b *Backend) available() []*Target {
+	targets := make([]*Target, 0, len(b.Targets))
+	for _, t := range b.Targets {
+		if t.Healthy() && t.circuit.allows() {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+func (b *Backend) runHealthChecks(t *Target, client *http.Client, stop chan struct{}) {
+	ticker := time.NewTicker(b.healthCheck.Interval)
+	defer ticker.Stop()
+
+	var successes, failures int
+	for {
+		err := b.check(t, client)
+		if err == nil {
+			successes++
+			failures = 0
+			if !t.Healthy() && successes >= b.healthCheck.HealthyThreshold {
+				t.setHealthy(true)
+			}
+		} else {
+			failures++
+			successes = 0
+			log.WithFields(log.Fields{
+				"reason": err,
+				"target": t.URL.String(),
+			}).Debug("Health check failed")
+			if t.Healthy() && failures >= b.healthCheck.UnhealthyThreshold {
+				t.setHealthy(false)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Backend) check(t *Target, client *http.Client) error {
+	hc := b.healthCheck
+
+	// Files are served as long as the root directory is available
+	if t.static != nil && hc.Type == "tcp" {
+		_, err := os.Stat(t.URL.Path)
+		return err
+	}
+
+	if hc.Type == "tcp" {
+		network, addr := "tcp", targetAddr(t)
+		if t.socket != "" {
+			network, addr = "unix", t.socket
+		}
+
+		conn, err := net.DialTimeout(network, addr, hc.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	u := *t.upstream
+	u.Path = singleJoiningSlash(u.Path, hc.Path)
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "svcproxy-health-check")
+
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+	defer cancel()
+
+	do := client.Do
+	if t.static != nil || t.socket != "" {
+		do = t.roundTripper(client.Transport).RoundTrip
+	}
+
+	resp, err := do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if len(hc.ExpectedStatus) == 0 {
+		if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+			return nil
+		}
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	for _, status := range hc.ExpectedStatus {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+}
+
+// targetAddr returns host:port pair for target URL
+// using default port for the scheme if not specified
+func targetAddr(t *Target) string {
+	if t.URL.Port() != "" {
+		return t.URL.Host
+	}
+
+	port := "80"
+	if t.URL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(t.URL.Hostname(), port)
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/suite"
+)
+
+type HealthCheckTestSuite struct {
+	suite.Suite
+}
+
+func (s *HealthCheckTestSuite) waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func (s *HealthCheckTestSuite) TestHTTPHealthCheck() {
+	var failing int32
+	var hits int64
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/prefix/healthz" {
+			if atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer flaky.Close()
+
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer stable.Close()
+
+	t1, err := NewTarget(flaky.URL+"/prefix", 1)
+	s.Require().NoError(err)
+	t2, err := NewTarget(stable.URL, 1)
+	s.Require().NoError(err)
+
+	b, err := NewBalancedBackend([]*Target{t1, t2}, &RoundRobinBalancer{}, nil)
+	s.Require().NoError(err)
+
+	err = b.SetHealthCheck(&HealthCheck{
+		Path:               "/healthz",
+		Interval:           10 * time.Millisecond,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+		ExpectedStatus:     []int{http.StatusOK},
+	}, http.DefaultTransport)
+	s.Require().NoError(err)
+	defer b.Close()
+
+	s.True(t1.Healthy())
+	s.True(t2.Healthy())
+
+	atomic.StoreInt32(&failing, 1)
+	s.Require().True(s.waitFor(func() bool { return !t1.Healthy() }))
+	s.True(t2.Healthy())
+	s.Equal([]*Target{t2}, b.available())
+
+	f, err := NewFrontend("test.local", "proxy", nil)
+	s.Require().NoError(err)
+	p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	for i := 0; i < 10; i++ {
+		r, err := http.NewRequest("GET", "http://test.local/", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		p.proxy.ServeHTTP(w, r)
+		s.Equal(http.StatusNoContent, w.Result().StatusCode)
+	}
+	s.Equal(int64(0), atomic.LoadInt64(&hits))
+
+	atomic.StoreInt32(&failing, 0)
+	s.Require().True(s.waitFor(func() bool { return t1.Healthy() }))
+	s.Len(b.available(), 2)
+}
+
+func (s *HealthCheckTestSuite) TestTCPHealthCheck() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	t, err := NewTarget("http://"+l.Addr().String(), 1)
+	s.Require().NoError(err)
+
+	b, err := NewBalancedBackend([]*Target{t}, nil, nil)
+	s.Require().NoError(err)
+
+	err = b.SetHealthCheck(&HealthCheck{
+		Type:     "tcp",
+		Interval: 10 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	}, http.DefaultTransport)
+	s.Require().NoError(err)
+	defer b.Close()
+
+	time.Sleep(30 * time.Millisecond)
+	s.True(t.Healthy())
+
+	l.Close()
+	s.Require().True(s.waitFor(func() bool { return !t.Healthy() }))
+	s.Empty(b.available())
+}
+
+func (s *HealthCheckTestSuite) TestMetrics() {
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer testsrv.Close()
+
+	backend := func(service, path string) *Backend {
+		b, err := NewBackend(testsrv.URL, nil)
+		s.Require().NoError(err)
+		b.SetService(service)
+		s.Require().NoError(b.SetHealthCheck(&HealthCheck{Path: path, Interval: 10 * time.Millisecond}, http.DefaultTransport))
+		return b
+	}
+
+	// Services with the same target don't overwrite each other's series
+	healthy, unhealthy := backend("healthy", "/ok"), backend("unhealthy", "/fail")
+	s.Require().True(s.waitFor(func() bool { return !unhealthy.Targets[0].Healthy() }))
+
+	value, ok := s.gauge(targetHealthy, "healthy", testsrv.URL)
+	s.True(ok)
+	s.Equal(float64(1), value)
+	value, ok = s.gauge(targetHealthy, "unhealthy", testsrv.URL)
+	s.True(ok)
+	s.Equal(float64(0), value)
+
+	// Series are kept until the last backend of the service is closed
+	replaced := backend("healthy", "/ok")
+	healthy.Close()
+	_, ok = s.gauge(targetHealthy, "healthy", testsrv.URL)
+	s.True(ok)
+
+	replaced.Close()
+	unhealthy.Close()
+	_, ok = s.gauge(targetHealthy, "healthy", testsrv.URL)
+	s.False(ok)
+	_, ok = s.gauge(targetHealthy, "unhealthy", testsrv.URL)
+	s.False(ok)
+}
+
+// gauge returns value of the target series, false is returned
+// if there's no such series
+func (s *HealthCheckTestSuite) gauge(g *prometheus.GaugeVec, service, target string) (float64, bool) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		g.Collect(ch)
+		close(ch)
+	}()
+
+	var value float64
+	var found bool
+	for m := range ch {
+		var pb dto.Metric
+		s.Require().NoError(m.Write(&pb))
+
+		labels := make(map[string]string)
+		for _, l := range pb.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["service"] == service && labels["target"] == target {
+			value, found = pb.Gauge.GetValue(), true
+		}
+	}
+	return value, found
+}
+
+func (s *HealthCheckTestSuite) TestInvalidHealthCheck() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	err = b.SetHealthCheck(&HealthCheck{}, http.DefaultTransport)
+	s.Error(err)
+
+	err = b.SetHealthCheck(&HealthCheck{Type: "udp", Interval: time.Second}, http.DefaultTransport)
+	s.Error(err)
+}
+
+func TestHealthCheckTestSuite(t *testing.T) {
+	suite.Run(t, new(HealthCheckTestSuite))
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// NormalizeHost converts host name (possibly with port) to the form
+// proxies are looked up by: port and trailing dot are stripped,
+// internationalized names are converted to punycode and
+// the whole name is lower-cased.
+func NormalizeHost(host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	if host == "" {
+		return "", fmt.Errorf("empty host name")
+	}
+
+	// IPv6 literals could come without port but in brackets
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if net.ParseIP(host) != nil {
+		return strings.ToLower(host), nil
+	}
+
+	wildcard := strings.HasPrefix(host, "*.")
+	if wildcard {
+		host = host[2:]
+	}
+
+	host, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", err
+	}
+	host = strings.ToLower(host)
+
+	if wildcard {
+		host = "*." + host
+	}
+	return host, nil
+}
+
+// lookup finds proxy for the host name passed. Exact matches are preferred
+// over wildcard ones, wildcard entries match exactly one label the way
+// wildcard certificates do, i.e. `*.example.com` matches `www.example.com`
+// but not `a.b.example.com`.
+func (s *Svc) lookup(host string) (*Proxy, bool) {
+	host, err := NormalizeHost(host)
+	if err != nil {
+		return nil, false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if p, ok := s.proxies[host]; ok {
+		return p, true
+	}
+
+	if i := strings.Index(host, "."); i >= 0 {
+		if p, ok := s.wildcards[host[i+1:]]; ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// HostPolicy implements autocert.HostPolicy allowing certificates to be
+// issued for host names served by the service including the ones matched
+// by wildcard entries.
+func (s *Svc) HostPolicy(_ context.Context, host string) error {
+	if _, ok := s.lookup(host); !ok {
+		return fmt.Errorf("host `%s` is not configured", host)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HostsTestSuite struct {
+	suite.Suite
+}
+
+func (s *HostsTestSuite) TestNormalizeHost() {
+	tcs := []struct {
+		input    string
+		expected string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "example.com"},
+		{"example.com:8443", "example.com"},
+		{"example.com.", "example.com"},
+		{"example.com.:8443", "example.com"},
+		{"*.Example.com", "*.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+		{"127.0.0.1:80", "127.0.0.1"},
+		{"[::1]:443", "::1"},
+		{"[::1]", "::1"},
+	}
+
+	for _, tc := range tcs {
+		host, err := NormalizeHost(tc.input)
+		s.Require().NoError(err)
+		s.Equalf(tc.expected, host, "input=%s", tc.input)
+	}
+
+	_, err := NormalizeHost("")
+	s.Error(err)
+}
+
+func (s *HostsTestSuite) TestLookup() {
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer testsrv.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	for _, fqdn := range []string{"example.com", "*.example.com", "*.tenant.example.com", "bücher.example"} {
+		f, err := NewFrontend(fqdn, "proxy", map[string]string{"X-Frontend": fqdn})
+		s.Require().NoError(err)
+
+		b, err := NewBackend(testsrv.URL, nil)
+		s.Require().NoError(err)
+
+		p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+
+		err = svc.AddProxy(p)
+		s.Require().NoError(err)
+	}
+
+	tcs := []struct {
+		host     string
+		frontend string
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.com:8443", "example.com"},
+		{"example.com.", "example.com"},
+		{"www.example.com", "*.example.com"},
+		{"a.b.example.com", ""},
+		{"a.b.tenant.example.com", ""},
+		{"tenant.example.com", "*.example.com"},
+		{"acme.tenant.example.com:443", "*.tenant.example.com"},
+		{"xn--bcher-kva.example", "bücher.example"},
+		{"example.org", ""},
+	}
+
+	for _, tc := range tcs {
+		r, err := http.NewRequest("GET", "http://localhost/", nil)
+		s.Require().NoError(err)
+		r.Host = tc.host
+
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, r)
+
+		result := w.Result()
+		if tc.frontend == "" {
+			s.Equalf(http.StatusNotFound, result.StatusCode, "host=%s", tc.host)
+			s.Error(svc.HostPolicy(context.Background(), tc.host))
+			continue
+		}
+		s.Equalf(http.StatusNoContent, result.StatusCode, "host=%s", tc.host)
+		s.Equalf(tc.frontend, result.Header.Get("X-Frontend"), "host=%s", tc.host)
+		s.NoError(svc.HostPolicy(context.Background(), tc.host))
+	}
+}
+
+func TestHostsTestSuite(t *testing.T) {
+	suite.Run(t, new(HostsTestSuite))
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaintenanceRetryAfter is passed in Retry-After header when
+// maintenance isn't limited by the window
+const DefaultMaintenanceRetryAfter = 5 * time.Minute
+
+// Maintenance defines maintenance mode of the service. Requests to the
+// service in maintenance get 503 Service Unavailable unless they bypass it.
+type Maintenance struct {
+	// Enabled turns maintenance mode on, it could be toggled at runtime
+	Enabled bool
+	// Windows are the periods the service is in maintenance
+	Windows []MaintenanceWindow
+	// RetryAfter is passed in Retry-After header, the time left till
+	// the end of the current window is passed if it's zero
+	RetryAfter time.Duration
+	// Page is the body of maintenance response, it's replaced by error
+	// page of 503 status if empty
+	Page        []byte
+	ContentType string
+
+	// BypassNetworks are client networks requests are passed to
+	// the backend from
+	BypassNetworks []string
+	// BypassHeader and BypassCookie are the names of header and cookie
+	// passing BypassSecret to get to the backend
+	BypassHeader string
+	BypassCookie string
+	BypassSecret string
+
+	networks []*net.IPNet
+}
+
+// MaintenanceWindow is the period of scheduled maintenance
+type MaintenanceWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// SetMaintenance sets maintenance mode settings of the proxy
+func (p *Proxy) SetMaintenance(m *Maintenance) error {
+	for _, w := range m.Windows {
+		if !w.End.After(w.Start) {
+			return fmt.Errorf("maintenance window must end after start: %s - %s", w.Start, w.End)
+		}
+	}
+
+	if m.BypassSecret == "" && (m.BypassHeader != "" || m.BypassCookie != "") {
+		return fmt.Errorf("bypass secret is required for bypass header and cookie")
+	}
+
+	m.networks = nil
+	for _, cidr := range m.BypassNetworks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		m.networks = append(m.networks, n)
+	}
+
+	if len(m.Page) > 0 && m.ContentType == "" {
+		m.ContentType = "text/html; charset=utf-8"
+	}
+
+	p.maintenance = m
+	p.setMaintenanceMode(m.Enabled)
+	return nil
+}
+
+// SetMaintenanceMode turns maintenance mode of the service on or off
+// at runtime, scheduled windows are applied regardless of it
+func (s *Svc) SetMaintenanceMode(name string, enabled bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	proxies, ok := s.services[name]
+	if !ok {
+		return ErrServiceNotFound
+	}
+	s.runtimeState(name).maintenance = &enabled
+	for _, p := range proxies {
+		p.setMaintenanceMode(enabled)
+	}
+	return nil
+}
+
+// MaintenanceMode returns true if maintenance mode is turned on
+// for proxy's service
+func (p *Proxy) MaintenanceMode() bool {
+	return atomic.LoadInt32(&p.maintenanceMode) == 1
+}
+
+func (p *Proxy) setMaintenanceMode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&p.maintenanceMode, v)
+}
+
+// inMaintenance tells if the service is in maintenance at the moment
+// passed, the end of the current window is returned if any
+func (p *Proxy) inMaintenance(now time.Time) (bool, time.Time) {
+	var end time.Time
+	for _, w := range p.maintenanceWindows() {
+		if !now.Before(w.Start) && now.Before(w.End) && w.End.After(end) {
+			end = w.End
+		}
+	}
+	return p.MaintenanceMode() || !end.IsZero(), end
+}
+
+func (p *Proxy) maintenanceWindows() []MaintenanceWindow {
+	if p.maintenance == nil {
+		return nil
+	}
+	return p.maintenance.Windows
+}
+
+// bypass tells if the request is allowed to get to the backend
+// during maintenance
+func (m *Maintenance) bypass(r *http.Request) bool {
+	if m == nil {
+		return false
+	}
+
+	if ip := net.ParseIP(clientFromRequest(r).IP); ip != nil {
+		for _, n := range m.networks {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	if m.BypassSecret == "" {
+		return false
+	}
+	if m.BypassHeader != "" && secretEqual(r.Header.Get(m.BypassHeader), m.BypassSecret) {
+		return true
+	}
+	if m.BypassCookie != "" {
+		if c, err := r.Cookie(m.BypassCookie); err == nil && secretEqual(c.Value, m.BypassSecret) {
+			return true
+		}
+	}
+	return false
+}
+
+func secretEqual(value, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(value), []byte(secret)) == 1
+}
+
+// serveMaintenance responds to the request if the service is in maintenance
+// and request doesn't bypass it, false is returned otherwise
+func (p *Proxy) serveMaintenance(w http.ResponseWriter, r *http.Request) bool {
+	now := time.Now()
+	active, end := p.inMaintenance(now)
+	if !active || p.maintenance.bypass(r) {
+		return false
+	}
+
+	retryAfter := DefaultMaintenanceRetryAfter
+	switch {
+	case p.maintenance != nil && p.maintenance.RetryAfter > 0:
+		retryAfter = p.maintenance.RetryAfter
+	case !end.IsZero() && !p.MaintenanceMode():
+		retryAfter = end.Sub(now)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	if p.maintenance == nil || len(p.maintenance.Page) == 0 {
+		http.Error(w, "service is under maintenance", http.StatusServiceUnavailable)
+		return true
+	}
+
+	// Maintenance page is configured explicitly so it's not replaced
+	// by error pages
+	if st := errorPageStateFromRequest(r); st != nil {
+		st.keepBody = true
+	}
+	w.Header().Set("Content-Type", p.maintenance.ContentType)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(p.maintenance.Page)
+	return true
+}
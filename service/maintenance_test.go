@@ -0,0 +1,148 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MaintenanceTestSuite struct {
+	suite.Suite
+
+	backend *httptest.Server
+	svc     *Svc
+	proxy   *Proxy
+}
+
+func (s *MaintenanceTestSuite) SetupTest() {
+	s.backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+	s.svc = svc
+
+	b, err := NewBackend(s.backend.URL, nil)
+	s.Require().NoError(err)
+	s.proxy, err = NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.proxy.Service = "test"
+	s.Require().NoError(s.svc.AddProxy(s.proxy))
+}
+
+func (s *MaintenanceTestSuite) TearDownTest() {
+	s.backend.Close()
+}
+
+func (s *MaintenanceTestSuite) TestRuntimeToggle() {
+	s.Equal(http.StatusNoContent, s.serve(nil).Code)
+
+	s.Require().NoError(s.svc.SetMaintenanceMode("test", true))
+	w := s.serve(nil)
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+	s.Equal("300", w.Header().Get("Retry-After"))
+
+	s.Require().NoError(s.svc.SetMaintenanceMode("test", false))
+	s.Equal(http.StatusNoContent, s.serve(nil).Code)
+
+	s.Equal(ErrServiceNotFound, s.svc.SetMaintenanceMode("unknown", true))
+}
+
+func (s *MaintenanceTestSuite) TestPage() {
+	s.Require().NoError(s.svc.SetErrorPages(&ErrorPages{
+		HTML: map[int]string{0: "error page"},
+	}))
+	s.Require().NoError(s.proxy.SetMaintenance(&Maintenance{
+		Enabled:    true,
+		RetryAfter: 90 * time.Second,
+		Page:       []byte("be back soon"),
+	}))
+
+	w := s.serve(nil)
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+	s.Equal("90", w.Header().Get("Retry-After"))
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("be back soon", w.Body.String())
+
+	// Error page is used without maintenance page
+	s.Require().NoError(s.proxy.SetMaintenance(&Maintenance{Enabled: true}))
+	w = s.serve(nil)
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+	s.Equal("error page", w.Body.String())
+}
+
+func (s *MaintenanceTestSuite) TestWindows() {
+	now := time.Now()
+	s.Require().NoError(s.proxy.SetMaintenance(&Maintenance{
+		Windows: []MaintenanceWindow{
+			{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)},
+			{Start: now.Add(-time.Minute), End: now.Add(10 * time.Minute)},
+		},
+	}))
+
+	w := s.serve(nil)
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+	s.Contains([]string{"600", "601"}, w.Header().Get("Retry-After"))
+
+	s.Require().NoError(s.proxy.SetMaintenance(&Maintenance{
+		Windows: []MaintenanceWindow{
+			{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+		},
+	}))
+	s.Equal(http.StatusNoContent, s.serve(nil).Code)
+}
+
+func (s *MaintenanceTestSuite) TestBypass() {
+	s.Require().NoError(s.proxy.SetMaintenance(&Maintenance{
+		Enabled:        true,
+		BypassNetworks: []string{"198.51.100.0/24"},
+		BypassHeader:   "X-Maintenance-Bypass",
+		BypassCookie:   "maintenance",
+		BypassSecret:   "secret",
+	}))
+
+	s.Equal(http.StatusServiceUnavailable, s.serve(nil).Code)
+
+	s.Equal(http.StatusNoContent, s.serve(func(r *http.Request) {
+		r.RemoteAddr = "198.51.100.1:1234"
+	}).Code)
+
+	s.Equal(http.StatusNoContent, s.serve(func(r *http.Request) {
+		r.Header.Set("X-Maintenance-Bypass", "secret")
+	}).Code)
+	s.Equal(http.StatusServiceUnavailable, s.serve(func(r *http.Request) {
+		r.Header.Set("X-Maintenance-Bypass", "wrong")
+	}).Code)
+
+	s.Equal(http.StatusNoContent, s.serve(func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "maintenance", Value: "secret"})
+	}).Code)
+}
+
+func (s *MaintenanceTestSuite) TestValidation() {
+	now := time.Now()
+	s.Error(s.proxy.SetMaintenance(&Maintenance{
+		Windows: []MaintenanceWindow{{Start: now, End: now}},
+	}))
+	s.Error(s.proxy.SetMaintenance(&Maintenance{BypassNetworks: []string{"invalid"}}))
+	s.Error(s.proxy.SetMaintenance(&Maintenance{BypassHeader: "X-Bypass"}))
+}
+
+func (s *MaintenanceTestSuite) serve(modify func(r *http.Request)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	if modify != nil {
+		modify(r)
+	}
+
+	w := httptest.NewRecorder()
+	s.svc.ErrorPagesHandler(s.svc).ServeHTTP(w, r)
+	return w
+}
+
+func TestMaintenanceTestSuite(t *testing.T) {
+	suite.Run(t, new(MaintenanceTestSuite))
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/teran/svcproxy/middleware/types"
+)
+
+// defaultMirrorMaxBodySize is the default maximum size of request body
+// buffered to be mirrored
+const defaultMirrorMaxBodySize = 64 * 1024
+
+// mirrorMaxInFlight limits amount of concurrent mirrored requests
+// per proxy, requests above the limit are not mirrored
+const mirrorMaxInFlight = 100
+
+// mirrorTimeout limits mirrored request duration
+const mirrorTimeout = 30 * time.Second
+
+var (
+	mirrorRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirror_requests_total",
+			Help: "A counter for requests mirrored, code is `error` for failed requests.",
+		},
+		[]string{"service", "code"},
+	)
+
+	mirrorResponseDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mirror_response_duration_seconds",
+			Help:    "A histogram of mirrored request latencies.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"service", "code"},
+	)
+
+	mirrorRequestsSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mirror_requests_skipped_total",
+			Help: "A counter for requests not mirrored due to body size or concurrency limits.",
+		},
+		[]string{"service", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(mirrorRequestsTotal)
+	prometheus.MustRegister(mirrorResponseDurationSeconds)
+	prometheus.MustRegister(mirrorRequestsSkippedTotal)
+}
+
+// Mirror defines asynchronous copying of requests to another backend,
+// mirror responses are discarded
+type Mirror struct {
+	Backend *Backend
+	// Percentage of requests to mirror
+	Percentage float64
+	// MaxBodySize is the maximum size of request body buffered to be
+	// mirrored, requests with bigger bodies are not mirrored
+	MaxBodySize int64
+}
+
+// mirror is the prepared Mirror definition
+type mirror struct {
+	Mirror
+	transport http.RoundTripper
+	inflight  chan struct{}
+}
+
+// SetMirror enables mirroring of proxy requests
+func (p *Proxy) SetMirror(m *Mirror, transport http.RoundTripper) error {
+	if m.Backend == nil {
+		return fmt.Errorf("mirror backend is required")
+	}
+	if m.Percentage <= 0 || m.Percentage > 100 {
+		return fmt.Errorf("mirror percentage must be between 0 and 100")
+	}
+	if m.MaxBodySize == 0 {
+		m.MaxBodySize = defaultMirrorMaxBodySize
+	}
+
+	p.mirror = &mirror{
+		Mirror: *m,
+		transport: &backendTransport{
+			backend: m.Backend,
+			next:    transport,
+		},
+		inflight: make(chan struct{}, mirrorMaxInFlight),
+	}
+	return nil
+}
+
+// send passes copy of the request to the mirror backend if request is
+// sampled. Request body is buffered and replaced so the primary request
+// is not affected.
+func (m *mirror) send(service string, r *http.Request) {
+	if m == nil || rand.Float64()*100 >= m.Percentage {
+		return
+	}
+
+	// Upgraded connections and gRPC streams couldn't be mirrored
+	if r.Header.Get("Upgrade") != "" || isGRPCRequest(r) {
+		return
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > m.MaxBodySize {
+			mirrorRequestsSkippedTotal.WithLabelValues(service, "bodySize").Inc()
+			return
+		}
+
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, m.MaxBodySize+1))
+		if err != nil || int64(len(body)) > m.MaxBodySize {
+			// Primary request gets the body read so far followed by the rest
+			r.Body = &replayedBody{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+			mirrorRequestsSkippedTotal.WithLabelValues(service, "bodySize").Inc()
+			return
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		mirrorRequestsSkippedTotal.WithLabelValues(service, "concurrency").Inc()
+		return
+	}
+
+	// Mirrored request must outlive the primary one
+	ctx, cancel := context.WithTimeout(mirrorContext(r), mirrorTimeout)
+	outreq := r.WithContext(ctx)
+	outreq.Header = cloneHeader(r.Header)
+	u := *r.URL
+	outreq.URL = &u
+	outreq.RequestURI = ""
+	outreq.Close = false
+	outreq.TransferEncoding = nil
+	outreq.ContentLength = int64(len(body))
+	outreq.Body = http.NoBody
+	if body != nil {
+		outreq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	for _, h := range hopHeaders {
+		outreq.Header.Del(h)
+	}
+	setRequestHeaders(outreq, m.Backend)
+	addPeerForwardedFor(outreq)
+	m.Backend.rewrite.apply(outreq.URL)
+
+	go func() {
+		defer func() { <-m.inflight }()
+		defer cancel()
+
+		start := time.Now()
+		resp, err := m.transport.RoundTrip(outreq)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":  err,
+				"service": service,
+			}).Debug("Mirrored request failed")
+			mirrorRequestsTotal.WithLabelValues(service, "error").Inc()
+			mirrorResponseDurationSeconds.WithLabelValues(service, "error").Observe(time.Since(start).Seconds())
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		code := strconv.Itoa(resp.StatusCode)
+		mirrorRequestsTotal.WithLabelValues(service, code).Inc()
+		mirrorResponseDurationSeconds.WithLabelValues(service, code).Observe(time.Since(start).Seconds())
+	}()
+}
+
+// mirrorContext returns context of the mirrored request detached from
+// the primary one. Values describing the client are copied for forwarding
+// and PROXY protocol headers to be the same as of the primary request.
+func mirrorContext(r *http.Request) context.Context {
+	ctx := context.WithValue(context.Background(), requestVarsKey{}, requestVarsFromRequest(r))
+	if c := types.ClientFromContext(r.Context()); c != nil {
+		ctx = types.WithClient(ctx, c)
+	}
+	for _, key := range []interface{}{forwardingKey{}, http.LocalAddrContextKey} {
+		if v := r.Context().Value(key); v != nil {
+			ctx = context.WithValue(ctx, key, v)
+		}
+	}
+	return ctx
+}
+
+// hopHeaders are connection specific headers not passed to mirror
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+}
+
+// replayedBody is request body partially read while buffering
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, vv := range h {
+		h2[k] = append([]string(nil), vv...)
+	}
+	return h2
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MirrorTestSuite struct {
+	suite.Suite
+}
+
+func (s *MirrorTestSuite) TestMirror() {
+	primary := make(chan string, 10)
+	primarySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		primary <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer primarySrv.Close()
+
+	release := make(chan struct{})
+	mirrored := make(chan *http.Request, 10)
+	mirroredBodies := make(chan string, 10)
+	mirrorSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mirrored <- r
+		mirroredBodies <- string(body)
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mirrorSrv.Close()
+	defer close(release)
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	b, err := NewBackend(primarySrv.URL, nil)
+	s.Require().NoError(err)
+
+	mb, err := NewBackend(mirrorSrv.URL, map[string]string{"X-Mirror": "yes"})
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	err = p.SetMirror(&Mirror{Backend: mb, Percentage: 100, MaxBodySize: 16}, http.DefaultTransport)
+	s.Require().NoError(err)
+	s.Require().NoError(svc.AddProxy(p))
+
+	serve := func(body string) int {
+		r := httptest.NewRequest("POST", "http://test.local/path?q=1", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, r)
+		return w.Result().StatusCode
+	}
+
+	// Primary response is not delayed by blocked mirror
+	s.Equal(http.StatusNoContent, serve("small body"))
+	s.Equal("small body", <-primary)
+
+	select {
+	case r := <-mirrored:
+		s.Equal("POST", r.Method)
+		s.Equal("/path", r.URL.Path)
+		s.Equal("q=1", r.URL.RawQuery)
+		s.Equal("yes", r.Header.Get("X-Mirror"))
+		s.Equal("small body", <-mirroredBodies)
+	case <-time.After(5 * time.Second):
+		s.Fail("request is not mirrored")
+	}
+
+	// Bodies above the limit are passed to primary backend only
+	s.Equal(http.StatusNoContent, serve("body bigger than the limit"))
+	s.Equal("body bigger than the limit", <-primary)
+
+	select {
+	case <-mirrored:
+		s.Fail("request with big body is mirrored")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func (s *MirrorTestSuite) TestTrustedProxies() {
+	headers := func(ch chan http.Header) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ch <- r.Header
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	primary := make(chan http.Header, 1)
+	primarySrv := headers(primary)
+	defer primarySrv.Close()
+	mirrored := make(chan http.Header, 1)
+	mirrorSrv := headers(mirrored)
+	defer mirrorSrv.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	b, err := NewBackend(primarySrv.URL, nil)
+	s.Require().NoError(err)
+	mb, err := NewBackend(mirrorSrv.URL, nil)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(p.SetMirror(&Mirror{Backend: mb, Percentage: 100}, http.DefaultTransport))
+	s.Require().NoError(svc.AddProxy(p))
+
+	f, err := NewForwarding([]string{"10.0.0.0/8"}, ForwardingBoth)
+	s.Require().NoError(err)
+
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	f.Handler(svc).ServeHTTP(w, r)
+	s.Equal(http.StatusNoContent, w.Result().StatusCode)
+
+	var h http.Header
+	select {
+	case h = <-mirrored:
+	case <-time.After(5 * time.Second):
+		s.FailNow("request is not mirrored")
+	}
+
+	// Mirror gets the client resolved through trusted proxies
+	ph := <-primary
+	s.Equal("203.0.113.1", h.Get("X-Real-IP"))
+	s.Equal("203.0.113.1, 10.0.0.1", h.Get("X-Forwarded-For"))
+	s.Equal("for=203.0.113.1, for=10.0.0.1;proto=https;host=test.local", h.Get("Forwarded"))
+	for _, name := range []string{"X-Real-Ip", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"} {
+		s.Equal(ph.Get(name), h.Get(name), name)
+	}
+}
+
+func (s *MirrorTestSuite) TestSetMirrorValidation() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	s.Error(p.SetMirror(&Mirror{Percentage: 10}, http.DefaultTransport))
+	s.Error(p.SetMirror(&Mirror{Backend: b, Percentage: 0}, http.DefaultTransport))
+	s.Error(p.SetMirror(&Mirror{Backend: b, Percentage: 101}, http.DefaultTransport))
+}
+
+func TestMirrorTestSuite(t *testing.T) {
+	suite.Run(t, new(MirrorTestSuite))
+}
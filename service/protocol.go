@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Protocols requests are passed to backend targets with
+const (
+	// ProtocolHTTP1 passes requests with HTTP/1.1, it's the default one
+	ProtocolHTTP1 = "http1"
+	// ProtocolH2 passes requests with HTTP/2 over TLS
+	ProtocolH2 = "h2"
+	// ProtocolH2C passes requests with HTTP/2 over cleartext connections
+	ProtocolH2C = "h2c"
+	// ProtocolGRPC passes requests with HTTP/2 over TLS for `https://`
+	// targets and over cleartext connections otherwise
+	ProtocolGRPC = "grpc"
+)
+
+// SetProtocol sets protocol requests are passed to the backend targets with.
+// Requests are passed via the copy of the transport, it must be called
+// before SetTLS and SetHealthCheck for them to use the protocol as well.
+func (b *Backend) SetProtocol(protocol string, transport *http.Transport) error {
+	protocols := new(http.Protocols)
+	switch protocol {
+	case "", ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case ProtocolH2:
+		protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	case ProtocolGRPC:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return fmt.Errorf("unknown backend protocol: `%s`", protocol)
+	}
+
+	for _, t := range b.Targets {
+		if t.static != nil && protocol != "" && protocol != ProtocolHTTP1 {
+			return fmt.Errorf("protocol `%s` is not supported by file targets", protocol)
+		}
+		if protocol == ProtocolH2 && t.upstream.Scheme != "https" {
+			return fmt.Errorf("protocol `%s` requires https target: `%s`", protocol, t.URL)
+		}
+		if protocol == ProtocolH2C && t.upstream.Scheme != "http" {
+			return fmt.Errorf("protocol `%s` requires http or unix target: `%s`", protocol, t.URL)
+		}
+	}
+
+	t := b.baseTransport(transport).Clone()
+	t.Protocols = protocols
+	b.transport = t
+	return nil
+}
+
+// baseTransport returns transport backend settings are applied on top of.
+// Transport set up by previous settings like PROXY protocol is kept, the
+// one passed is used otherwise.
+func (b *Backend) baseTransport(transport *http.Transport) *http.Transport {
+	if t, ok := b.transport.(*http.Transport); ok {
+		return t
+	}
+	return transport
+}
+
+// nextProtos returns ALPN protocols of the transport
+func nextProtos(t *http.Transport) []string {
+	if t.Protocols == nil || t.Protocols.HTTP1() && !t.Protocols.HTTP2() {
+		return nil
+	}
+
+	var protos []string
+	if t.Protocols.HTTP2() {
+		protos = append(protos, "h2")
+	}
+	if t.Protocols.HTTP1() {
+		protos = append(protos, "http/1.1")
+	}
+	return protos
+}
+
+// isGRPCRequest tells if request is gRPC call
+func isGRPCRequest(r *http.Request) bool {
+	return isGRPCContentType(r.Header.Get("Content-Type"))
+}
+
+func isGRPCContentType(contentType string) bool {
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// gRPC status codes responses are mapped to
+const (
+	grpcStatusUnknown          = 2
+	grpcStatusPermissionDenied = 7
+	grpcStatusUnimplemented    = 12
+	grpcStatusInternal         = 13
+	grpcStatusUnavailable      = 14
+	grpcStatusUnauthenticated  = 16
+)
+
+// grpcStatus maps HTTP status to gRPC one as gRPC clients do it
+func grpcStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcStatusInternal
+	case http.StatusUnauthorized:
+		return grpcStatusUnauthenticated
+	case http.StatusForbidden:
+		return grpcStatusPermissionDenied
+	case http.StatusNotFound:
+		return grpcStatusUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcStatusUnavailable
+	}
+	return grpcStatusUnknown
+}
+
+// grpcMessage percent-encodes status message to be passed in
+// `grpc-message` header
+func grpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// GRPCErrorsHandler makes failed gRPC calls end with `grpc-status`
+// describing the failure. Responses of svcproxy itself and non-gRPC
+// responses of backends with status other than 200 are replaced with
+// trailers-only gRPC responses, gRPC clients can't read them otherwise.
+func GRPCErrorsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isGRPCRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&grpcErrorWriter{ResponseWriter: w}, r)
+	})
+}
+
+// grpcErrorWriter replaces error responses with gRPC status
+type grpcErrorWriter struct {
+	http.ResponseWriter
+
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *grpcErrorWriter) WriteHeader(status int) {
+	// Informational responses are followed by the final one
+	if w.wroteHeader || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if status == http.StatusOK || isGRPCContentType(h.Get("Content-Type")) {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	for _, name := range []string{"Content-Encoding", "Content-Length", "Transfer-Encoding", "Trailer"} {
+		h.Del(name)
+	}
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(grpcStatus(status)))
+	h.Set("Grpc-Message", grpcMessage(fmt.Sprintf("%d %s", status, http.StatusText(status))))
+
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	w.replaced = true
+}
+
+// Write discards the body of replaced responses
+func (w *grpcErrorWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (w *grpcErrorWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *grpcErrorWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if ok {
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("Hijacker is not implemented in underlying ResponseWriter")
+}
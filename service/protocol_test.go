@@ -0,0 +1,413 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type ProtocolTestSuite struct {
+	suite.Suite
+}
+
+// grpcFrame returns length-prefixed gRPC message
+func grpcFrame(msg string) []byte {
+	b := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(msg)))
+	copy(b[5:], msg)
+	return b
+}
+
+func readGRPCFrame(r io.Reader) (string, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return "", err
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:5]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+// grpcEchoServer is in-process gRPC server echoing messages of the call
+// back as soon as they're received
+func grpcEchoServer(tls bool) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !isGRPCRequest(r) {
+			http.Error(w, "gRPC over HTTP/2 is expected", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		for {
+			msg, err := readGRPCFrame(r.Body)
+			if err != nil {
+				break
+			}
+			w.Write(grpcFrame("echo: " + msg))
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"X-Calls", "1")
+	}))
+
+	if tls {
+		srv.EnableHTTP2 = true
+		srv.StartTLS()
+		return srv
+	}
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	return srv
+}
+
+// echoServiceDesc describes gRPC service echoing string messages back,
+// `fail` message of unary call is answered with InvalidArgument status
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Say",
+		Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if in.Value == "fail" {
+				return nil, status.Error(codes.InvalidArgument, "failed on purpose")
+			}
+			grpc.SetTrailer(ctx, metadata.Pairs("x-calls", "1"))
+			return wrapperspb.String("echo: " + in.Value), nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Chat",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(_ interface{}, stream grpc.ServerStream) error {
+			for {
+				in := new(wrapperspb.StringValue)
+				if err := stream.RecvMsg(in); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := stream.SendMsg(wrapperspb.String("echo: " + in.Value)); err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+// grpcServer runs google.golang.org/grpc server of echo service
+// returning its URL
+func (s *ProtocolTestSuite) grpcServer() (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	srv := grpc.NewServer()
+	srv.RegisterService(&echoServiceDesc, struct{}{})
+	go srv.Serve(l)
+	return "http://" + l.Addr().String(), srv.Stop
+}
+
+// grpcClient connects google.golang.org/grpc client to the frontend
+func (s *ProtocolTestSuite) grpcClient(frontend *httptest.Server) *grpc.ClientConn {
+	conn, err := grpc.NewClient(strings.TrimPrefix(frontend.URL, "http://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	s.Require().NoError(err)
+	return conn
+}
+
+// frontend runs h2c server passing requests to the backend the way
+// svcproxy listener does
+func (s *ProtocolTestSuite) frontend(b *Backend) (*httptest.Server, *http.Client) {
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	srv := httptest.NewUnstartedServer(GRPCErrorsHandler(p.proxy))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+
+	t := &http.Transport{Protocols: new(http.Protocols)}
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return srv, &http.Client{Transport: t}
+}
+
+func (s *ProtocolTestSuite) backend(url, protocol string) *Backend {
+	b, err := NewBackend(url, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetProtocol(protocol, http.DefaultTransport.(*http.Transport)))
+	return b
+}
+
+func (s *ProtocolTestSuite) TestHTTP2() {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetHTTP1(true)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	defer upstream.Close()
+
+	for protocol, expected := range map[string]string{
+		ProtocolHTTP1: "HTTP/1.1",
+		ProtocolH2C:   "HTTP/2.0",
+	} {
+		b := s.backend(upstream.URL, protocol)
+		p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		p.proxy.ServeHTTP(w, httptest.NewRequest("GET", "http://test.local/", nil))
+		s.Equal(expected, w.Body.String(), protocol)
+	}
+
+	// HTTP/2 is negotiated over TLS set up by backend TLS settings
+	tlsUpstream := httptest.NewUnstartedServer(upstream.Config.Handler)
+	tlsUpstream.EnableHTTP2 = true
+	tlsUpstream.StartTLS()
+	defer tlsUpstream.Close()
+
+	b := s.backend(tlsUpstream.URL, ProtocolH2)
+	s.Require().NoError(b.SetTLS(&BackendTLS{InsecureSkipVerify: true}, http.DefaultTransport.(*http.Transport)))
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	w := httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, httptest.NewRequest("GET", "http://test.local/", nil))
+	s.Equal("HTTP/2.0", w.Body.String())
+}
+
+func (s *ProtocolTestSuite) TestGRPCUnary() {
+	for _, tls := range []bool{false, true} {
+		upstream := grpcEchoServer(tls)
+
+		b := s.backend(upstream.URL, ProtocolGRPC)
+		if tls {
+			s.Require().NoError(b.SetTLS(&BackendTLS{InsecureSkipVerify: true}, http.DefaultTransport.(*http.Transport)))
+		}
+		frontend, client := s.frontend(b)
+
+		r, err := http.NewRequest("POST", frontend.URL+"/test.Echo/Say", bytes.NewReader(grpcFrame("hello")))
+		s.Require().NoError(err)
+		r.Header.Set("Content-Type", "application/grpc")
+		r.Header.Set("Te", "trailers")
+
+		resp, err := client.Do(r)
+		s.Require().NoError(err)
+
+		msg, err := readGRPCFrame(resp.Body)
+		s.Require().NoError(err)
+		s.Equal("echo: hello", msg)
+
+		_, err = ioutil.ReadAll(resp.Body)
+		s.Require().NoError(err)
+		resp.Body.Close()
+		s.Equal("0", resp.Trailer.Get("Grpc-Status"))
+		s.Equal("1", resp.Trailer.Get("X-Calls"))
+
+		frontend.Close()
+		upstream.Close()
+	}
+}
+
+func (s *ProtocolTestSuite) TestGRPCStreaming() {
+	upstream := grpcEchoServer(false)
+	defer upstream.Close()
+	frontend, client := s.frontend(s.backend(upstream.URL, ProtocolGRPC))
+	defer frontend.Close()
+
+	pr, pw := io.Pipe()
+	r, err := http.NewRequest("POST", frontend.URL+"/test.Echo/Chat", pr)
+	s.Require().NoError(err)
+	r.Header.Set("Content-Type", "application/grpc+proto")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pw.Write(grpcFrame("first"))
+		done <- err
+	}()
+	resp, err := client.Do(r)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	s.Require().NoError(<-done)
+
+	// Each message is echoed before the next one is sent
+	for _, m := range []string{"first", "second", "third"} {
+		if m != "first" {
+			_, err := pw.Write(grpcFrame(m))
+			s.Require().NoError(err)
+		}
+		msg, err := readGRPCFrame(resp.Body)
+		s.Require().NoError(err)
+		s.Equal("echo: "+m, msg)
+	}
+	pw.Close()
+
+	_, err = readGRPCFrame(resp.Body)
+	s.Equal(io.EOF, err)
+	s.Equal("0", resp.Trailer.Get("Grpc-Status"))
+}
+
+func (s *ProtocolTestSuite) TestGRPCErrors() {
+	upstream := grpcEchoServer(false)
+	upstream.Close()
+
+	// Non-gRPC error responses of the backend are replaced too
+	notFound := httptest.NewUnstartedServer(http.NotFoundHandler())
+	notFound.Config.Protocols = new(http.Protocols)
+	notFound.Config.Protocols.SetUnencryptedHTTP2(true)
+	notFound.Start()
+	defer notFound.Close()
+
+	for url, expected := range map[string]string{
+		upstream.URL: "14",
+		notFound.URL: "12",
+	} {
+		frontend, client := s.frontend(s.backend(url, ProtocolH2C))
+
+		r, err := http.NewRequest("POST", frontend.URL+"/test.Echo/Say", bytes.NewReader(grpcFrame("hello")))
+		s.Require().NoError(err)
+		r.Header.Set("Content-Type", "application/grpc")
+
+		resp, err := client.Do(r)
+		s.Require().NoError(err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		s.Equal(http.StatusOK, resp.StatusCode, url)
+		s.Equal("application/grpc", resp.Header.Get("Content-Type"), url)
+		s.Equal(expected, resp.Header.Get("Grpc-Status"), url)
+		s.NotEmpty(resp.Header.Get("Grpc-Message"), url)
+		s.Empty(body, url)
+
+		frontend.Close()
+	}
+
+	// Responses to other requests are kept intact
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "http://test.local/", nil)
+	GRPCErrorsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	})).ServeHTTP(w, r)
+	s.Equal(http.StatusBadGateway, w.Code)
+	s.Empty(w.Header().Get("Grpc-Status"))
+}
+
+func (s *ProtocolTestSuite) TestGRPCClientUnary() {
+	url, stop := s.grpcServer()
+	defer stop()
+	frontend, _ := s.frontend(s.backend(url, ProtocolGRPC))
+	defer frontend.Close()
+	conn := s.grpcClient(frontend)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var trailer metadata.MD
+	out := new(wrapperspb.StringValue)
+	err := conn.Invoke(ctx, "/test.Echo/Say", wrapperspb.String("hello"), out, grpc.Trailer(&trailer))
+	s.Require().NoError(err)
+	s.Equal("echo: hello", out.Value)
+	s.Equal([]string{"1"}, trailer.Get("x-calls"))
+
+	// Trailers-only responses of the backend are passed intact
+	err = conn.Invoke(ctx, "/test.Echo/Say", wrapperspb.String("fail"), out)
+	s.Equal(codes.InvalidArgument, status.Code(err))
+	s.Equal("failed on purpose", status.Convert(err).Message())
+
+	// Responses of svcproxy itself are read by clients as gRPC status
+	stop()
+	err = conn.Invoke(ctx, "/test.Echo/Say", wrapperspb.String("hello"), out)
+	s.Equal(codes.Unavailable, status.Code(err))
+	s.Equal("502 Bad Gateway", status.Convert(err).Message())
+}
+
+func (s *ProtocolTestSuite) TestGRPCClientStreaming() {
+	url, stop := s.grpcServer()
+	defer stop()
+	frontend, _ := s.frontend(s.backend(url, ProtocolGRPC))
+	defer frontend.Close()
+	conn := s.grpcClient(frontend)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := conn.NewStream(ctx, &echoServiceDesc.Streams[0], "/test.Echo/Chat")
+	s.Require().NoError(err)
+
+	// Each message is echoed before the next one is sent
+	for _, m := range []string{"first", "second", "third"} {
+		s.Require().NoError(stream.SendMsg(wrapperspb.String(m)))
+
+		out := new(wrapperspb.StringValue)
+		s.Require().NoError(stream.RecvMsg(out))
+		s.Equal("echo: "+m, out.Value)
+	}
+	s.Require().NoError(stream.CloseSend())
+	s.Equal(io.EOF, stream.RecvMsg(new(wrapperspb.StringValue)))
+}
+
+func (s *ProtocolTestSuite) TestGRPCMessage() {
+	s.Equal("502 Bad Gateway", grpcMessage("502 Bad Gateway"))
+	s.Equal("100%25 caf%C3%A9%0A", grpcMessage("100% café\n"))
+	s.Equal(grpcStatusUnauthenticated, grpcStatus(http.StatusUnauthorized))
+	s.Equal(grpcStatusUnknown, grpcStatus(http.StatusInternalServerError))
+}
+
+func (s *ProtocolTestSuite) TestValidation() {
+	t := http.DefaultTransport.(*http.Transport)
+
+	for url, protocol := range map[string]string{
+		"http://localhost:8080":  "spdy",
+		"http://localhost:8081":  ProtocolH2,
+		"https://localhost:8443": ProtocolH2C,
+		"file:///srv/site":       ProtocolGRPC,
+	} {
+		b, err := NewBackend(url, nil)
+		s.Require().NoError(err)
+		s.Error(b.SetProtocol(protocol, t), url)
+	}
+
+	b, err := NewBackend("unix:///run/app.sock", nil)
+	s.Require().NoError(err)
+	s.NoError(b.SetProtocol(ProtocolH2C, t))
+	s.True(b.transport.(*http.Transport).Protocols.UnencryptedHTTP2())
+
+	// ALPN is set up only for protocols other than HTTP/1.1
+	b, err = NewBackend("https://localhost:8443", nil)
+	s.Require().NoError(err)
+	s.NoError(b.SetProtocol(ProtocolGRPC, t))
+	s.Equal([]string{"h2"}, nextProtos(b.transport.(*http.Transport)))
+	s.Nil(nextProtos(t))
+}
+
+func TestProtocolTestSuite(t *testing.T) {
+	suite.Run(t, new(ProtocolTestSuite))
+}
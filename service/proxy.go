@@ -2,7 +2,6 @@ package service
 
 import (
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
 
@@ -28,34 +27,54 @@ func NewProxy(frontend *Frontend, backend *Backend, authenticator authentication
 
 // NewReverseProxy returns httputil.ReverseProxy object for particular backend
 func NewReverseProxy(backend *Backend, transport http.RoundTripper) *httputil.ReverseProxy {
+	// Request URL is pointed to the particular target by backendTransport
 	director := func(r *http.Request) {
-		r.URL.Scheme = backend.URL.Scheme
-		r.URL.Host = backend.URL.Host
-		r.URL.Path = singleJoiningSlash(backend.URL.Path, r.URL.Path)
+		setRequestHeaders(r, backend)
+		backend.rewrite.apply(r.URL)
+	}
 
-		if backend.URL.RawQuery == "" || r.URL.RawQuery == "" {
-			r.URL.RawQuery = backend.URL.RawQuery + r.URL.RawQuery
-		} else {
-			r.URL.RawQuery = backend.URL.RawQuery + "&" + r.URL.RawQuery
+	rp := &httputil.ReverseProxy{
+		Director: director,
+		Transport: &backendTransport{
+			backend: backend,
+			next:    transport,
+		},
+	}
+	rp.ModifyResponse = func(resp *http.Response) error {
+		// Error pages replace backend responses only if asked to
+		if st := errorPageStateFromRequest(resp.Request); st != nil {
+			st.upstream = true
 		}
-
-		remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
-		if remoteIP == "" {
-			remoteIP = "0.0.0.0"
+		backend.responseRewrite.apply(resp, backend)
+		applyResponseHeaders(resp.Header, resp.Request)
+		return nil
+	}
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if rp.ErrorLog != nil {
+			rp.ErrorLog.Printf("http: proxy error: %v", err)
+		} else {
+			log.Printf("http: proxy error: %v", err)
 		}
 
-		r.Header.Set("X-Forwarded-For", remoteIP)
-		r.Header.Set("X-Real-IP", remoteIP)
-		r.Header.Set("X-Forwarded-Proto", "https")
-		r.Header.Set("X-Proxy-App", "svcproxy")
+		applyResponseHeaders(w.Header(), r)
 
-		for h, v := range backend.requestHTTPHeaders {
-			r.Header.Set(h, v)
+		// Fail fast when there's no targets to pass request to
+		if err == ErrNoTargetsAvailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		}
+		w.WriteHeader(http.StatusBadGateway)
 	}
+	return rp
+}
+
+// setRequestHeaders sets headers of the request passed to the backend
+func setRequestHeaders(r *http.Request, backend *Backend) {
+	setForwardingHeaders(r)
+	r.Header.Set("X-Proxy-App", "svcproxy")
 
-	return &httputil.ReverseProxy{
-		Director:  director,
-		Transport: transport,
+	for h, v := range backend.requestHTTPHeaders {
+		r.Header.Set(h, v)
 	}
+	applyHeaderRules(r.Header, backend.requestHeaders, requestVarsFromRequest(r))
 }
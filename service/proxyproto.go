@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/teran/svcproxy/proxyproto"
+)
+
+type proxyHeaderKey struct{}
+
+// SetProxyProtocol makes the backend send PROXY protocol header of the given
+// version with client and destination addresses on each connection to its
+// targets. Requests are passed via the copy of the transport, connections
+// are not reused since the header describes the single client. It must be
+// called before SetHealthCheck for health checks to send the header as well.
+func (b *Backend) SetProxyProtocol(version int, transport *http.Transport) error {
+	if version != 1 && version != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version: %d", version)
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	t := transport.Clone()
+	// Header must be received by the target itself
+	t.Proxy = nil
+	t.DisableKeepAlives = true
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// Connections made without client, e.g. by health checks,
+		// pass the header without addresses
+		h, ok := ctx.Value(proxyHeaderKey{}).(*proxyproto.Header)
+		if !ok {
+			h = &proxyproto.Header{}
+		}
+		h.Version = version
+
+		if _, err := conn.Write(h.Format()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	b.transport = t
+	return nil
+}
+
+// withProxyHeader returns request carrying PROXY protocol header
+// describing its client
+func withProxyHeader(r *http.Request) *http.Request {
+	c := clientFromRequest(r)
+	h := &proxyproto.Header{}
+
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if ip := net.ParseIP(c.IP); ip != nil && local != nil {
+		// Port is known only if client is the connection peer
+		var port int
+		if host, p, err := net.SplitHostPort(r.RemoteAddr); err == nil && host == c.IP {
+			port, _ = strconv.Atoi(p)
+		}
+
+		h.Source = &net.TCPAddr{IP: ip, Port: port}
+		h.Destination = local
+	}
+	return r.WithContext(context.WithValue(r.Context(), proxyHeaderKey{}, h))
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/teran/svcproxy/proxyproto"
+)
+
+type ProxyProtocolTestSuite struct {
+	suite.Suite
+}
+
+// loopback returns networks svcproxy connects to the test targets from
+func (s *ProxyProtocolTestSuite) loopback() []*net.IPNet {
+	_, n, err := net.ParseCIDR("127.0.0.0/8")
+	s.Require().NoError(err)
+	return []*net.IPNet{n}
+}
+
+// serve runs target behind PROXY protocol listener, reads are limited in
+// time for wrong trust settings to fail tests instead of hanging them
+func (s *ProxyProtocolTestSuite) serve(l net.Listener, h http.HandlerFunc) *http.Server {
+	srv := &http.Server{Handler: h, ReadTimeout: time.Second}
+	go srv.Serve(&proxyproto.Listener{
+		Listener:       l,
+		Required:       true,
+		TrustedSources: s.loopback(),
+		HeaderTimeout:  time.Second,
+	})
+	return srv
+}
+
+func (s *ProxyProtocolTestSuite) TestProxy() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	srv := s.serve(l, func(w http.ResponseWriter, r *http.Request) {
+		local := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		fmt.Fprintf(w, "%s %s", r.RemoteAddr, local)
+	})
+	defer srv.Close()
+
+	for _, version := range []int{1, 2} {
+		b, err := NewBackend("http://"+l.Addr().String(), nil)
+		s.Require().NoError(err)
+		s.Require().NoError(b.SetProxyProtocol(version, &http.Transport{ResponseHeaderTimeout: time.Second}))
+
+		p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+
+		serve := func(remoteAddr string) string {
+			r := httptest.NewRequest("GET", "http://test.local/", nil)
+			r.RemoteAddr = remoteAddr
+			local := &net.TCPAddr{IP: net.ParseIP("192.0.2.100"), Port: 443}
+			r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+
+			w := httptest.NewRecorder()
+			p.proxy.ServeHTTP(w, r)
+			s.Require().Equal(http.StatusOK, w.Result().StatusCode)
+
+			body, err := ioutil.ReadAll(w.Result().Body)
+			s.Require().NoError(err)
+			return string(body)
+		}
+
+		// Each client gets its own connection
+		s.Equal("192.0.2.1:1234 192.0.2.100:443", serve("192.0.2.1:1234"), version)
+		s.Equal("192.0.2.2:4321 192.0.2.100:443", serve("192.0.2.2:4321"), version)
+	}
+}
+
+func (s *ProxyProtocolTestSuite) TestHealthCheck() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	checked := make(chan string, 1)
+	srv := s.serve(l, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case checked <- r.RemoteAddr:
+		default:
+		}
+	})
+	defer srv.Close()
+
+	b, err := NewBackend("http://"+l.Addr().String(), nil)
+	s.Require().NoError(err)
+	defer b.Close()
+
+	s.Require().NoError(b.SetProxyProtocol(2, &http.Transport{ResponseHeaderTimeout: time.Second}))
+	s.Require().NoError(b.SetHealthCheck(&HealthCheck{Interval: 10 * time.Millisecond}, http.DefaultTransport))
+
+	var remoteAddr string
+	select {
+	case remoteAddr = <-checked:
+	case <-time.After(5 * time.Second):
+		s.FailNow("target wasn't checked")
+	}
+
+	// LOCAL header keeps connection addresses
+	host, _, err := net.SplitHostPort(remoteAddr)
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1", host)
+}
+
+func (s *ProxyProtocolTestSuite) TestValidation() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	s.Error(b.SetProxyProtocol(3, &http.Transport{}))
+}
+
+func TestProxyProtocolTestSuite(t *testing.T) {
+	suite.Run(t, new(ProxyProtocolTestSuite))
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrServiceNotFound is returned for operations on unknown services
+var ErrServiceNotFound = errors.New("service not found")
+
+// ErrHostConflict is returned when service FQDN is already served
+// by another service
+var ErrHostConflict = errors.New("host is served by another service")
+
+// runtimeState is the state of the service changed via admin API,
+// it's kept on configuration reload
+type runtimeState struct {
+	// added is set for services added or replaced via admin API
+	added    bool
+	draining bool
+	// maintenance overrides maintenance mode of the configuration if set
+	maintenance *bool
+}
+
+// ServiceBuilder creates proxies of the service by its definition
+type ServiceBuilder func(name string, definition []byte) ([]*Proxy, error)
+
+// SetServiceBuilder sets the function used to create services
+// added via admin API
+func (s *Svc) SetServiceBuilder(build ServiceBuilder) {
+	s.build = build
+}
+
+// ServiceNames returns sorted list of the service names
+func (s *Svc) ServiceNames() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, 0, len(s.services))
+	for name := range s.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServiceProxies returns proxies of the service
+func (s *Svc) ServiceProxies(name string) ([]*Proxy, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	proxies, ok := s.services[name]
+	if !ok {
+		return nil, ErrServiceNotFound
+	}
+	return append([]*Proxy(nil), proxies...), nil
+}
+
+// PutService adds the service or replaces the existing one with the same
+// name. Drain mode of replaced service is kept. Returns true if service
+// was created.
+func (s *Svc) PutService(name string, proxies []*Proxy) (bool, error) {
+	if len(proxies) == 0 {
+		return false, fmt.Errorf("service `%s` has no proxies", name)
+	}
+
+	hosts := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		fqdn, err := NormalizeHost(p.Frontend.FQDN)
+		if err != nil {
+			return false, err
+		}
+		hosts = append(hosts, fqdn)
+	}
+
+	s.mutex.Lock()
+	for _, fqdn := range hosts {
+		if owner, ok := s.owner(fqdn); ok && owner.Service != name {
+			s.mutex.Unlock()
+			return false, fmt.Errorf("%s: `%s` is served by `%s`", ErrHostConflict, fqdn, owner.Service)
+		}
+	}
+
+	old, exists := s.services[name]
+	draining := exists && old[0].Draining()
+	maintenance := exists && old[0].MaintenanceMode()
+
+	s.removeService(name)
+	for i, p := range proxies {
+		p.Service = name
+		p.setDraining(draining)
+		if maintenance {
+			p.setMaintenanceMode(true)
+		}
+		addHost(s.proxies, s.wildcards, hosts[i], p)
+	}
+	s.services[name] = proxies
+	s.runtimeState(name).added = true
+	s.mutex.Unlock()
+
+	// Closing waits for health checks in flight, requests must not
+	// wait for it
+	CloseProxies(old)
+	return !exists, nil
+}
+
+// RemoveService stops serving the service and closes its backends
+func (s *Svc) RemoveService(name string) error {
+	s.mutex.Lock()
+	old, ok := s.services[name]
+	if !ok {
+		s.mutex.Unlock()
+		return ErrServiceNotFound
+	}
+	s.removeService(name)
+	delete(s.runtime, name)
+	s.mutex.Unlock()
+
+	CloseProxies(old)
+	return nil
+}
+
+// SetDraining enables or disables drain mode for the service. Draining
+// service rejects new requests with 503 while requests in flight complete.
+func (s *Svc) SetDraining(name string, draining bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	proxies, ok := s.services[name]
+	if !ok {
+		return ErrServiceNotFound
+	}
+	s.runtimeState(name).draining = draining
+	for _, p := range proxies {
+		p.setDraining(draining)
+	}
+	return nil
+}
+
+// Draining returns true if proxy's service is in drain mode
+func (p *Proxy) Draining() bool {
+	return atomic.LoadInt32(&p.draining) == 1
+}
+
+func (p *Proxy) setDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&p.draining, v)
+}
+
+// owner returns proxy registered for the exact normalized host name
+func (s *Svc) owner(fqdn string) (*Proxy, bool) {
+	return hostOwner(s.proxies, s.wildcards, fqdn)
+}
+
+func hostOwner(proxies, wildcards map[string]*Proxy, fqdn string) (*Proxy, bool) {
+	if strings.HasPrefix(fqdn, "*.") {
+		p, ok := wildcards[fqdn[2:]]
+		return p, ok
+	}
+	p, ok := proxies[fqdn]
+	return p, ok
+}
+
+// runtimeState returns state of the service changed via admin API,
+// must be called with mutex held
+func (s *Svc) runtimeState(name string) *runtimeState {
+	st, ok := s.runtime[name]
+	if !ok {
+		st = &runtimeState{}
+		s.runtime[name] = st
+	}
+	return st
+}
+
+// keepRuntimeServices adds services added via admin API to the new ones
+// unless their names or hosts are taken and applies runtime modes to
+// the new services, names of kept services are returned. Must be called
+// with mutex held.
+func (s *Svc) keepRuntimeServices(services map[string][]*Proxy, proxies, wildcards map[string]*Proxy) map[string]bool {
+	kept := make(map[string]bool)
+	for name, st := range s.runtime {
+		if !st.added {
+			continue
+		}
+
+		old := s.services[name]
+		if owner, taken := servicesOwner(services, proxies, wildcards, name, old); taken {
+			log.WithFields(log.Fields{
+				"service": name,
+				"owner":   owner,
+			}).Warn("Service added via admin API is replaced by configuration")
+			delete(s.runtime, name)
+			continue
+		}
+
+		for _, p := range old {
+			addProxy(proxies, wildcards, p)
+		}
+		services[name] = old
+		kept[name] = true
+	}
+
+	for name, st := range s.runtime {
+		sp, ok := services[name]
+		if !ok {
+			delete(s.runtime, name)
+			continue
+		}
+		if kept[name] {
+			continue
+		}
+		for _, p := range sp {
+			p.setDraining(st.draining)
+			if st.maintenance != nil {
+				p.setMaintenanceMode(*st.maintenance)
+			}
+		}
+	}
+	return kept
+}
+
+// servicesOwner returns the name of the service taking name or one of
+// hosts of the proxies passed
+func servicesOwner(services map[string][]*Proxy, proxies, wildcards map[string]*Proxy, name string, sp []*Proxy) (string, bool) {
+	if _, ok := services[name]; ok {
+		return name, true
+	}
+	for _, p := range sp {
+		fqdn, err := NormalizeHost(p.Frontend.FQDN)
+		if err != nil {
+			continue
+		}
+		if owner, ok := hostOwner(proxies, wildcards, fqdn); ok {
+			return owner.Service, true
+		}
+	}
+	return "", false
+}
+
+// removeService removes service proxies from lookup maps,
+// must be called with mutex held
+func (s *Svc) removeService(name string) {
+	for fqdn, p := range s.proxies {
+		if p.Service == name {
+			delete(s.proxies, fqdn)
+		}
+	}
+	for suffix, p := range s.wildcards {
+		if p.Service == name {
+			delete(s.wildcards, suffix)
+		}
+	}
+	delete(s.services, name)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ResponseRewrite defines rewriting of backend response headers
+// pointing to backend addresses back to the public ones.
+// `$scheme` and `$host` placeholders in replacements are substituted
+// with client request scheme and host.
+type ResponseRewrite struct {
+	// TargetRedirects rewrites absolute URLs pointing to any of backend
+	// targets to the public scheme and host
+	TargetRedirects bool
+	// Redirects rewrite URLs in Location, Content-Location and Refresh
+	// headers starting with From prefix
+	Redirects []ResponseRewriteRule
+	// CookieDomains rewrite Set-Cookie Domain attribute equal to From,
+	// the attribute is removed if To is empty
+	CookieDomains []ResponseRewriteRule
+	// CookiePaths rewrite Set-Cookie Path attribute starting with From prefix
+	CookiePaths []ResponseRewriteRule
+}
+
+// ResponseRewriteRule replaces From value with To
+type ResponseRewriteRule struct {
+	From string
+	To   string
+}
+
+// SetResponseRewrite sets rewriting of headers of responses
+// received from the backend
+func (b *Backend) SetResponseRewrite(rr *ResponseRewrite) error {
+	for _, rules := range [][]ResponseRewriteRule{rr.Redirects, rr.CookieDomains, rr.CookiePaths} {
+		for _, rule := range rules {
+			if rule.From == "" {
+				return fmt.Errorf("value to replace is required for response rewrite rule")
+			}
+		}
+	}
+
+	b.responseRewrite = rr
+	return nil
+}
+
+// apply rewrites response headers according to the rules
+func (rr *ResponseRewrite) apply(resp *http.Response, backend *Backend) {
+	if rr == nil || resp.Request == nil {
+		return
+	}
+
+	rv := requestVarsFromRequest(resp.Request)
+	scheme, host := rv.scheme, rv.host
+	vars := strings.NewReplacer("$scheme", scheme, "$host", host)
+
+	for _, h := range []string{"Location", "Content-Location"} {
+		if v := resp.Header.Get(h); v != "" {
+			resp.Header.Set(h, rr.rewriteURL(v, backend, scheme, host, vars))
+		}
+	}
+
+	if v := resp.Header.Get("Refresh"); v != "" {
+		resp.Header.Set("Refresh", rr.rewriteRefresh(v, backend, scheme, host, vars))
+	}
+
+	if len(rr.CookieDomains) > 0 || len(rr.CookiePaths) > 0 {
+		cookies := resp.Header["Set-Cookie"]
+		for i, c := range cookies {
+			cookies[i] = rr.rewriteCookie(c, vars)
+		}
+	}
+}
+
+func (rr *ResponseRewrite) rewriteURL(u string, backend *Backend, scheme, host string, vars *strings.Replacer) string {
+	for _, rule := range rr.Redirects {
+		if strings.HasPrefix(u, rule.From) {
+			return vars.Replace(rule.To) + u[len(rule.From):]
+		}
+	}
+
+	if rr.TargetRedirects {
+		for _, t := range backend.Targets {
+			prefix := t.URL.Scheme + "://" + t.URL.Host + strings.TrimSuffix(t.URL.Path, "/")
+			if !strings.HasPrefix(strings.ToLower(u), strings.ToLower(prefix)) {
+				continue
+			}
+
+			rest := u[len(prefix):]
+			if rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasPrefix(rest, "?") {
+				continue
+			}
+			return scheme + "://" + host + rest
+		}
+	}
+	return u
+}
+
+// rewriteRefresh rewrites URL in Refresh header like `5; url=http://example.com/`
+func (rr *ResponseRewrite) rewriteRefresh(v string, backend *Backend, scheme, host string, vars *strings.Replacer) string {
+	i := strings.Index(strings.ToLower(v), "url=")
+	if i < 0 {
+		return v
+	}
+	return v[:i+4] + rr.rewriteURL(v[i+4:], backend, scheme, host, vars)
+}
+
+// rewriteCookie rewrites Domain and Path attributes of Set-Cookie header
+// value keeping the rest of it untouched
+func (rr *ResponseRewrite) rewriteCookie(c string, vars *strings.Replacer) string {
+	parts := strings.Split(c, ";")
+	result := []string{parts[0]}
+
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		i := strings.Index(attr, "=")
+		if i < 0 {
+			result = append(result, part)
+			continue
+		}
+		name, value := attr[:i], attr[i+1:]
+
+		switch strings.ToLower(name) {
+		case "domain":
+			domain := strings.TrimPrefix(value, ".")
+			rewritten := false
+			for _, rule := range rr.CookieDomains {
+				if strings.EqualFold(domain, strings.TrimPrefix(rule.From, ".")) {
+					value = vars.Replace(rule.To)
+					rewritten = true
+					break
+				}
+			}
+			if rewritten {
+				if value == "" {
+					continue
+				}
+				// Port is not the part of cookie domain
+				if j := strings.LastIndex(value, ":"); j >= 0 && !strings.Contains(value, "]") {
+					value = value[:j]
+				}
+				part = " " + name + "=" + value
+			}
+		case "path":
+			for _, rule := range rr.CookiePaths {
+				if strings.HasPrefix(value, rule.From) {
+					path := vars.Replace(rule.To)
+					if rest := value[len(rule.From):]; rest != "" {
+						path = singleJoiningSlash(path, rest)
+					}
+					part = " " + name + "=" + path
+					break
+				}
+			}
+		}
+		result = append(result, part)
+	}
+	return strings.Join(result, ";")
+}
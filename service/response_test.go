@@ -0,0 +1,136 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ResponseRewriteTestSuite struct {
+	suite.Suite
+}
+
+func (s *ResponseRewriteTestSuite) TestApply() {
+	b, err := NewBackend("http://backend.local:8080/app", nil)
+	s.Require().NoError(err)
+
+	err = b.SetResponseRewrite(&ResponseRewrite{
+		TargetRedirects: true,
+		Redirects: []ResponseRewriteRule{
+			{From: "http://internal.local/", To: "$scheme://$host/internal/"},
+		},
+		CookieDomains: []ResponseRewriteRule{
+			{From: "backend.local", To: "$host"},
+			{From: "internal.local"},
+		},
+		CookiePaths: []ResponseRewriteRule{
+			{From: "/app", To: "/"},
+		},
+	})
+	s.Require().NoError(err)
+
+	tcs := []struct {
+		name   string
+		header string
+		in     []string
+		out    []string
+	}{
+		{
+			name:   "target redirect",
+			header: "Location",
+			in:     []string{"http://backend.local:8080/app/login?next=1"},
+			out:    []string{"https://test.local/login?next=1"},
+		},
+		{
+			name:   "target redirect on path boundary only",
+			header: "Location",
+			in:     []string{"http://backend.local:8080/application"},
+			out:    []string{"http://backend.local:8080/application"},
+		},
+		{
+			name:   "prefix redirect",
+			header: "Content-Location",
+			in:     []string{"http://internal.local/docs/1"},
+			out:    []string{"https://test.local/internal/docs/1"},
+		},
+		{
+			name:   "relative redirect",
+			header: "Location",
+			in:     []string{"/login"},
+			out:    []string{"/login"},
+		},
+		{
+			name:   "refresh",
+			header: "Refresh",
+			in:     []string{"5; url=http://backend.local:8080/app/"},
+			out:    []string{"5; url=https://test.local/"},
+		},
+		{
+			name:   "cookies",
+			header: "Set-Cookie",
+			in: []string{
+				"session=1; Domain=.backend.local; Path=/app/admin; HttpOnly",
+				"token=2; Path=/other; Domain=internal.local",
+				"id=3; Domain=example.com",
+			},
+			out: []string{
+				"session=1; Domain=test.local; Path=/admin; HttpOnly",
+				"token=2; Path=/other",
+				"id=3; Domain=example.com",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		r := httptest.NewRequest("GET", "https://test.local:8443/", nil)
+		r.Host = "test.local"
+
+		resp := &http.Response{
+			Header:  http.Header{tc.header: tc.in},
+			Request: r,
+		}
+		b.responseRewrite.apply(resp, b)
+		s.Equal(tc.out, resp.Header[tc.header], tc.name)
+	}
+}
+
+func (s *ResponseRewriteTestSuite) TestProxy() {
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Domain: "127.0.0.1"})
+		http.Redirect(w, r, "http://"+r.Host+"/login", http.StatusFound)
+	}))
+	defer testsrv.Close()
+
+	b, err := NewBackend(testsrv.URL, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetResponseRewrite(&ResponseRewrite{
+		TargetRedirects: true,
+		CookieDomains:   []ResponseRewriteRule{{From: "127.0.0.1", To: "$host"}},
+	}))
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	w := httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, r)
+
+	s.Equal(http.StatusFound, w.Result().StatusCode)
+	s.Equal("http://test.local/login", w.Result().Header.Get("Location"))
+	s.Equal("session=1; Domain=test.local", w.Result().Header.Get("Set-Cookie"))
+}
+
+func (s *ResponseRewriteTestSuite) TestValidation() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	s.Error(b.SetResponseRewrite(&ResponseRewrite{Redirects: []ResponseRewriteRule{{To: "/"}}}))
+	s.Error(b.SetResponseRewrite(&ResponseRewrite{CookieDomains: []ResponseRewriteRule{{}}}))
+	s.Error(b.SetResponseRewrite(&ResponseRewrite{CookiePaths: []ResponseRewriteRule{{To: "/"}}}))
+}
+
+func TestResponseRewriteTestSuite(t *testing.T) {
+	suite.Run(t, new(ResponseRewriteTestSuite))
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/teran/svcproxy/middleware/types"
+)
+
+const (
+	// RetryOnConnectError retries requests failed to connect to the target.
+	// Such requests are never passed to target so they're retried
+	// regardless of request method.
+	RetryOnConnectError = "connectError"
+
+	// RetryOnError retries idempotent requests failed with any transport
+	// error like connection reset or per-try timeout
+	RetryOnError = "error"
+)
+
+// retryBudgetWindow is the period retry budget is calculated for
+const retryBudgetWindow = 10 * time.Second
+
+// retryBudgetMinRetries is amount of retries always allowed per window
+// to keep retries working on low traffic
+const retryBudgetMinRetries = 3
+
+// defaultRetryMaxBodySize is the default maximum size of request body
+// buffered for retries
+const defaultRetryMaxBodySize = 64 * 1024
+
+// RetryPolicy defines retrying of failed upstream requests
+type RetryPolicy struct {
+	// MaxAttempts is the total amount of attempts including the first one
+	MaxAttempts int
+	// RetryOn is the list of failure kinds to retry on:
+	// RetryOnConnectError and/or RetryOnError
+	RetryOn []string
+	// StatusCodes is the list of response status codes to retry idempotent
+	// requests on
+	StatusCodes []int
+	// PerTryTimeout limits the time of each attempt, no limit if zero
+	PerTryTimeout time.Duration
+	// Budget is the maximum percentage of retries relative to requests
+	// passed to the backend, no limit if zero
+	Budget float64
+	// MaxBodySize is the maximum size of request body buffered to be
+	// replayed on retry, requests with bigger bodies are never retried
+	MaxBodySize int64
+}
+
+// SetRetryPolicy enables retrying of failed requests to the backend
+func (b *Backend) SetRetryPolicy(rp *RetryPolicy) error {
+	if rp.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be positive")
+	}
+	if rp.Budget < 0 || rp.Budget > 100 {
+		return fmt.Errorf("retry budget must be percentage between 0 and 100")
+	}
+	if rp.MaxBodySize == 0 {
+		rp.MaxBodySize = defaultRetryMaxBodySize
+	}
+	for _, on := range rp.RetryOn {
+		switch on {
+		case RetryOnConnectError, RetryOnError:
+		default:
+			return fmt.Errorf("unknown retryOn value `%s`", on)
+		}
+	}
+
+	b.retryPolicy = rp
+	b.retryBudget = &retryBudget{percent: rp.Budget}
+	return nil
+}
+
+func (bt *backendTransport) roundTripWithRetries(r *http.Request, rp *RetryPolicy) (*http.Response, error) {
+	budget := bt.backend.retryBudget
+	budget.request()
+
+	maxAttempts := rp.MaxAttempts
+
+	// Request body is buffered to be replayed on retries
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength < 0 || r.ContentLength > rp.MaxBodySize {
+			maxAttempts = 1
+		} else {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stats := types.RequestStatsFromContext(r.Context())
+	idempotent := isIdempotent(r.Method)
+	tried := make(map[*Target]bool)
+
+	t := bt.pick(r, tried)
+	if t == nil {
+		return nil, ErrNoTargetsAvailable
+	}
+
+	for attempt := 1; ; attempt++ {
+		tried[t] = true
+
+		outreq := r
+		cancel := func() {}
+		if rp.PerTryTimeout > 0 {
+			var ctx context.Context
+			ctx, cancel = context.WithTimeout(r.Context(), rp.PerTryTimeout)
+			outreq = r.WithContext(ctx)
+		}
+		if body != nil {
+			outreq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := bt.try(outreq, t, cancel)
+		if attempt >= maxAttempts || r.Context().Err() != nil || !rp.retryable(resp, err, idempotent) {
+			return resp, err
+		}
+
+		next := bt.pick(r, tried)
+		if next == nil || !budget.allow() {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+
+		if stats != nil {
+			stats.AddRetry()
+		}
+		t = next
+	}
+}
+
+func (rp *RetryPolicy) retryable(resp *http.Response, err error, idempotent bool) bool {
+	if err != nil {
+		for _, on := range rp.RetryOn {
+			if on == RetryOnConnectError && isConnectError(err) {
+				return true
+			}
+			if on == RetryOnError && idempotent {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !idempotent {
+		return false
+	}
+	for _, status := range rp.StatusCodes {
+		if resp.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+// retryBudget limits amount of retries to the percentage of requests
+type retryBudget struct {
+	mutex    sync.Mutex
+	percent  float64
+	window   time.Time
+	requests int
+	retries  int
+}
+
+func (rb *retryBudget) request() {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.refresh()
+	rb.requests++
+}
+
+func (rb *retryBudget) allow() bool {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.refresh()
+	if rb.percent > 0 && rb.retries >= retryBudgetMinRetries &&
+		float64(rb.retries) >= float64(rb.requests)*rb.percent/100 {
+		return false
+	}
+	rb.retries++
+	return true
+}
+
+func (rb *retryBudget) refresh() {
+	if time.Since(rb.window) > retryBudgetWindow {
+		rb.window = time.Now()
+		rb.requests = 0
+		rb.retries = 0
+	}
+}
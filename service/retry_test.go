@@ -0,0 +1,175 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/teran/svcproxy/middleware/types"
+)
+
+type RetryTestSuite struct {
+	suite.Suite
+}
+
+func (s *RetryTestSuite) newProxy(policy *RetryPolicy, addresses ...string) *Proxy {
+	var targets []*Target
+	for _, addr := range addresses {
+		t, err := NewTarget(addr, 1)
+		s.Require().NoError(err)
+		targets = append(targets, t)
+	}
+
+	b, err := NewBalancedBackend(targets, &RoundRobinBalancer{}, nil)
+	s.Require().NoError(err)
+
+	err = b.SetRetryPolicy(policy)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	return p
+}
+
+func (s *RetryTestSuite) serve(p *Proxy, method, body string) (*http.Response, int64) {
+	r := httptest.NewRequest(method, "http://test.local/", strings.NewReader(body))
+	stats := &types.RequestStats{}
+	r = r.WithContext(types.WithRequestStats(r.Context(), stats))
+
+	w := httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, r)
+	return w.Result(), stats.Retries()
+}
+
+func (s *RetryTestSuite) TestRetryOnStatus() {
+	var failing, healthy int64
+	failingSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&failing, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failingSrv.Close()
+
+	healthySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&healthy, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer healthySrv.Close()
+
+	p := s.newProxy(&RetryPolicy{
+		MaxAttempts: 2,
+		StatusCodes: []int{http.StatusServiceUnavailable},
+	}, failingSrv.URL, healthySrv.URL)
+
+	// Round robin balancer passes each request to the failing target first
+	for i := 0; i < 4; i++ {
+		resp, retries := s.serve(p, "GET", "")
+		s.Equal(http.StatusNoContent, resp.StatusCode)
+		s.Equal(int64(1), retries)
+	}
+	s.Equal(int64(4), atomic.LoadInt64(&failing))
+	s.Equal(int64(4), atomic.LoadInt64(&healthy))
+
+	// Non-idempotent requests are never retried on status
+	resp, retries := s.serve(p, "POST", "data")
+	s.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	s.Equal(int64(0), retries)
+}
+
+func (s *RetryTestSuite) TestRetryOnConnectError() {
+	deadSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadSrv.Close()
+
+	healthySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		s.Require().NoError(err)
+		s.Equal("payload", string(body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer healthySrv.Close()
+
+	p := s.newProxy(&RetryPolicy{
+		MaxAttempts: 2,
+		RetryOn:     []string{RetryOnConnectError},
+	}, deadSrv.URL, healthySrv.URL)
+
+	for i := 0; i < 4; i++ {
+		resp, _ := s.serve(p, "POST", "payload")
+		s.Equal(http.StatusCreated, resp.StatusCode)
+	}
+}
+
+func (s *RetryTestSuite) TestPerTryTimeout() {
+	slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer slowSrv.Close()
+
+	fastSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fastSrv.Close()
+
+	p := s.newProxy(&RetryPolicy{
+		MaxAttempts:   2,
+		RetryOn:       []string{RetryOnError},
+		PerTryTimeout: 50 * time.Millisecond,
+	}, slowSrv.URL, fastSrv.URL)
+
+	for i := 0; i < 2; i++ {
+		resp, _ := s.serve(p, "GET", "")
+		s.Equal(http.StatusOK, resp.StatusCode)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		s.Require().NoError(err)
+		s.Equal("fast", string(body))
+	}
+}
+
+func (s *RetryTestSuite) TestBudget() {
+	var hits int64
+	failingSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failingSrv.Close()
+
+	p := s.newProxy(&RetryPolicy{
+		MaxAttempts: 2,
+		StatusCodes: []int{http.StatusBadGateway},
+		Budget:      10,
+	}, failingSrv.URL)
+
+	var total int64
+	for i := 0; i < 20; i++ {
+		resp, retries := s.serve(p, "GET", "")
+		s.Equal(http.StatusBadGateway, resp.StatusCode)
+		total += retries
+	}
+
+	// Minimal amount of retries is allowed even if it exceeds the budget
+	s.Equal(int64(retryBudgetMinRetries), total)
+	s.Equal(int64(20+retryBudgetMinRetries), atomic.LoadInt64(&hits))
+}
+
+func (s *RetryTestSuite) TestInvalidPolicy() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	s.Error(b.SetRetryPolicy(&RetryPolicy{}))
+	s.Error(b.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, Budget: 120}))
+	s.Error(b.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, RetryOn: []string{"timeout"}}))
+}
+
+func TestRetryTestSuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Rewrite defines modification of request URL before it's passed
+// to the backend. Path rules are applied to URL-encoded path in order:
+// prefix stripping, regular expression replacement, prefix adding.
+// Query parameters are removed before the new ones are added.
+type Rewrite struct {
+	// StripPrefix removes the prefix from request path
+	// if path starts with it on segment boundary
+	StripPrefix string
+	// AddPrefix prepends the prefix to request path
+	AddPrefix string
+	// Regex is matched against request path which is replaced
+	// by Replacement supporting capture groups like `$1` or `${name}`
+	Regex       string
+	Replacement string
+	// AddQuery sets query parameters replacing existing values
+	AddQuery map[string]string
+	// RemoveQuery removes query parameters
+	RemoveQuery []string
+
+	pattern *regexp.Regexp
+}
+
+// SetRewrite sets rewrite rules applied to requests passed to the backend
+func (b *Backend) SetRewrite(rw *Rewrite) error {
+	if rw.StripPrefix != "" && !strings.HasPrefix(rw.StripPrefix, "/") {
+		return fmt.Errorf("prefix to strip must start with slash: `%s`", rw.StripPrefix)
+	}
+	if rw.AddPrefix != "" && !strings.HasPrefix(rw.AddPrefix, "/") {
+		return fmt.Errorf("prefix to add must start with slash: `%s`", rw.AddPrefix)
+	}
+
+	if rw.Regex != "" {
+		pattern, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return err
+		}
+		rw.pattern = pattern
+	}
+
+	b.rewrite = rw
+	return nil
+}
+
+// apply rewrites URL according to the rules
+func (rw *Rewrite) apply(u *url.URL) {
+	if rw == nil {
+		return
+	}
+
+	path := u.EscapedPath()
+	if rw.StripPrefix != "" {
+		path = stripPrefix(path, rw.StripPrefix)
+	}
+	if rw.pattern != nil {
+		path = rw.pattern.ReplaceAllString(path, rw.Replacement)
+	}
+	if rw.AddPrefix != "" {
+		path = singleJoiningSlash(rw.AddPrefix, path)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	setEscapedPath(u, path)
+
+	if len(rw.RemoveQuery) > 0 || len(rw.AddQuery) > 0 {
+		query := u.Query()
+		for _, k := range rw.RemoveQuery {
+			query.Del(k)
+		}
+		for k, v := range rw.AddQuery {
+			query.Set(k, v)
+		}
+		u.RawQuery = query.Encode()
+	}
+}
+
+// stripPrefix removes prefix from path if path starts with it
+// on segment boundary
+func stripPrefix(path, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(path, prefix) {
+		return path
+	}
+
+	rest := path[len(prefix):]
+	if rest == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(rest, "/") {
+		return path
+	}
+	return rest
+}
+
+// setEscapedPath sets both Path and RawPath of the URL from URL-encoded
+// path keeping encoded characters like `%2F` untouched
+func setEscapedPath(u *url.URL, escaped string) {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		// Path is left as is if rewritten one is not valid
+		return
+	}
+
+	u.Path = path
+	u.RawPath = ""
+	if u.EscapedPath() != escaped {
+		u.RawPath = escaped
+	}
+}
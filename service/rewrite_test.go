@@ -0,0 +1,119 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RewriteTestSuite struct {
+	suite.Suite
+}
+
+func (s *RewriteTestSuite) TestApply() {
+	tcs := []struct {
+		name    string
+		rewrite *Rewrite
+		in      string
+		out     string
+	}{
+		{
+			name:    "strip prefix",
+			rewrite: &Rewrite{StripPrefix: "/api"},
+			in:      "/api/users?id=1",
+			out:     "/users?id=1",
+		},
+		{
+			name:    "strip prefix to root",
+			rewrite: &Rewrite{StripPrefix: "/api/"},
+			in:      "/api",
+			out:     "/",
+		},
+		{
+			name:    "strip prefix on segment boundary only",
+			rewrite: &Rewrite{StripPrefix: "/api"},
+			in:      "/apis/users",
+			out:     "/apis/users",
+		},
+		{
+			name:    "add prefix",
+			rewrite: &Rewrite{AddPrefix: "/v2/"},
+			in:      "/users",
+			out:     "/v2/users",
+		},
+		{
+			name:    "strip and add prefix",
+			rewrite: &Rewrite{StripPrefix: "/api", AddPrefix: "/internal"},
+			in:      "/api/users",
+			out:     "/internal/users",
+		},
+		{
+			name:    "regex with capture groups",
+			rewrite: &Rewrite{Regex: `^/users/(?P<id>\d+)/profile$`, Replacement: "/profiles/${id}"},
+			in:      "/users/42/profile",
+			out:     "/profiles/42",
+		},
+		{
+			name:    "encoded path is kept",
+			rewrite: &Rewrite{StripPrefix: "/api"},
+			in:      "/api/files/a%2Fb",
+			out:     "/files/a%2Fb",
+		},
+		{
+			name:    "query parameters",
+			rewrite: &Rewrite{AddQuery: map[string]string{"source": "svcproxy", "id": "2"}, RemoveQuery: []string{"token"}},
+			in:      "/users?id=1&token=secret",
+			out:     "/users?id=2&source=svcproxy",
+		},
+	}
+
+	for _, tc := range tcs {
+		b, err := NewBackend("http://localhost", nil)
+		s.Require().NoError(err, tc.name)
+		s.Require().NoError(b.SetRewrite(tc.rewrite), tc.name)
+
+		u, err := url.Parse(tc.in)
+		s.Require().NoError(err, tc.name)
+
+		b.rewrite.apply(u)
+		s.Equal(tc.out, u.RequestURI(), tc.name)
+	}
+}
+
+func (s *RewriteTestSuite) TestProxy() {
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-URI", r.RequestURI)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer testsrv.Close()
+
+	b, err := NewBackend(testsrv.URL+"/base", nil)
+	s.Require().NoError(err)
+	s.Require().NoError(b.SetRewrite(&Rewrite{StripPrefix: "/api", RemoveQuery: []string{"debug"}}))
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	r := httptest.NewRequest("GET", "http://test.local/api/files/a%2Fb?debug=1&q=x", nil)
+	w := httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, r)
+
+	s.Equal(http.StatusNoContent, w.Result().StatusCode)
+	s.Equal("/base/files/a%2Fb?q=x", w.Result().Header.Get("X-Request-URI"))
+}
+
+func (s *RewriteTestSuite) TestValidation() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	s.Error(b.SetRewrite(&Rewrite{StripPrefix: "api"}))
+	s.Error(b.SetRewrite(&Rewrite{AddPrefix: "api"}))
+	s.Error(b.SetRewrite(&Rewrite{Regex: "("}))
+}
+
+func TestRewriteTestSuite(t *testing.T) {
+	suite.Run(t, new(RewriteTestSuite))
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RouteMatch defines the way route path is matched against request path
+type RouteMatch string
+
+const (
+	// RouteMatchPrefix matches request paths starting with route path
+	RouteMatchPrefix RouteMatch = "prefix"
+
+	// RouteMatchExact matches request path equal to route path
+	RouteMatchExact RouteMatch = "exact"
+
+	// RouteMatchRegex matches request path against regular expression
+	RouteMatchRegex RouteMatch = "regex"
+)
+
+// NewRoute creates new Route instance
+func NewRoute(match, path string, backend *Backend, transport http.RoundTripper, logger *log.Logger) (*Route, error) {
+	route := &Route{
+		Match:   RouteMatch(match),
+		Path:    path,
+		Backend: backend,
+	}
+
+	switch route.Match {
+	case "":
+		route.Match = RouteMatchPrefix
+		fallthrough
+	case RouteMatchPrefix, RouteMatchExact:
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("route path must start with slash: `%s`", path)
+		}
+	case RouteMatchRegex:
+		pattern, err := regexp.Compile(path)
+		if err != nil {
+			return nil, err
+		}
+		route.pattern = pattern
+	default:
+		return nil, fmt.Errorf("unknown route match type `%s`", match)
+	}
+
+	route.proxy = NewReverseProxy(backend, transport)
+	if logger != nil {
+		route.proxy.ErrorLog = logger
+	}
+
+	return route, nil
+}
+
+// Matches checks if request path matches the route
+func (rt *Route) Matches(path string) bool {
+	switch rt.Match {
+	case RouteMatchExact:
+		return path == rt.Path
+	case RouteMatchPrefix:
+		if path == rt.Path || strings.HasSuffix(rt.Path, "/") {
+			return strings.HasPrefix(path, rt.Path)
+		}
+		return strings.HasPrefix(path, rt.Path+"/")
+	case RouteMatchRegex:
+		return rt.pattern.MatchString(path)
+	}
+	return false
+}
+
+// AddRoute adds route to the proxy keeping routes in lookup order:
+// exact matches first, then regular expressions in order they were added
+// and prefixes from the longest to the shortest. Regular expressions go
+// before prefixes so they're not shadowed by shorter paths like `/`.
+func (p *Proxy) AddRoute(rt *Route) error {
+	p.Routes = append(p.Routes, rt)
+	sort.SliceStable(p.Routes, func(i, j int) bool {
+		return routePriority(p.Routes[i]) < routePriority(p.Routes[j])
+	})
+	return nil
+}
+
+// route returns the first route matching request path or nil
+func (p *Proxy) route(path string) *Route {
+	for _, rt := range p.Routes {
+		if rt.Matches(path) {
+			return rt
+		}
+	}
+	return nil
+}
+
+func routePriority(rt *Route) int {
+	switch rt.Match {
+	case RouteMatchExact:
+		return 0
+	case RouteMatchRegex:
+		return 1
+	}
+	// Longer prefixes go first, they all are placed after
+	// exact and regex matches
+	return 1<<21 - len(rt.Path)
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RouteTestSuite struct {
+	suite.Suite
+}
+
+func (s *RouteTestSuite) TestMatches() {
+	tcs := []struct {
+		match    string
+		path     string
+		request  string
+		expected bool
+	}{
+		{"prefix", "/v1", "/v1", true},
+		{"prefix", "/v1", "/v1/users", true},
+		{"prefix", "/v1", "/v10", false},
+		{"prefix", "/v1/", "/v1/users", true},
+		{"", "/static", "/static/app.js", true},
+		{"exact", "/login", "/login", true},
+		{"exact", "/login", "/login/", false},
+		{"regex", "^/users/[0-9]+$", "/users/42", true},
+		{"regex", "^/users/[0-9]+$", "/users/me", false},
+	}
+
+	for _, tc := range tcs {
+		b, err := NewBackend("http://localhost", nil)
+		s.Require().NoError(err)
+
+		rt, err := NewRoute(tc.match, tc.path, b, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+		s.Equalf(tc.expected, rt.Matches(tc.request), "match=%s path=%s request=%s", tc.match, tc.path, tc.request)
+	}
+}
+
+func (s *RouteTestSuite) TestInvalidRoutes() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+
+	_, err = NewRoute("prefix", "v1", b, http.DefaultTransport, nil)
+	s.Error(err)
+
+	_, err = NewRoute("regex", "^/users/([0-9+$", b, http.DefaultTransport, nil)
+	s.Error(err)
+
+	_, err = NewRoute("glob", "/*", b, http.DefaultTransport, nil)
+	s.Error(err)
+}
+
+func (s *RouteTestSuite) TestRouting() {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend", name)
+			w.Header().Set("X-Route-Header", r.Header.Get("X-Route-Header"))
+			w.Header().Set("X-Path", r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+
+	defaultSrv := newBackend("default")
+	defer defaultSrv.Close()
+	v1Srv := newBackend("v1")
+	defer v1Srv.Close()
+	v1AdminSrv := newBackend("v1-admin")
+	defer v1AdminSrv.Close()
+	exactSrv := newBackend("exact")
+	defer exactSrv.Close()
+	regexSrv := newBackend("regex")
+	defer regexSrv.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	f, err := NewFrontend("api.test.local", "proxy", nil)
+	s.Require().NoError(err)
+
+	b, err := NewBackend(defaultSrv.URL, nil)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	routes := []struct {
+		match   string
+		path    string
+		url     string
+		headers map[string]string
+	}{
+		{"regex", "^/v1/admin/users/[0-9]+$", regexSrv.URL, nil},
+		{"prefix", "/v1", v1Srv.URL, map[string]string{"X-Route-Header": "v1"}},
+		{"prefix", "/v1/admin", v1AdminSrv.URL, nil},
+		{"exact", "/v1/admin/login", exactSrv.URL, nil},
+	}
+	for _, rd := range routes {
+		rb, err := NewBackend(rd.url, rd.headers)
+		s.Require().NoError(err)
+
+		rt, err := NewRoute(rd.match, rd.path, rb, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+
+		err = p.AddRoute(rt)
+		s.Require().NoError(err)
+	}
+
+	svc.AddProxy(p)
+
+	tcs := []struct {
+		path        string
+		backend     string
+		routeHeader string
+	}{
+		{"/", "default", ""},
+		{"/v10", "default", ""},
+		{"/v1", "v1", "v1"},
+		{"/v1/users", "v1", "v1"},
+		{"/v1/admin", "v1-admin", ""},
+		{"/v1/admin/settings", "v1-admin", ""},
+		{"/v1/admin/login", "exact", ""},
+		{"/v1/admin/users/42", "regex", ""},
+		{"/v1/admin/users/me", "v1-admin", ""},
+	}
+
+	for _, tc := range tcs {
+		r, err := http.NewRequest("GET", "http://api.test.local"+tc.path, nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, r)
+
+		result := w.Result()
+		s.Equal(http.StatusNoContent, result.StatusCode)
+		s.Equalf(tc.backend, result.Header.Get("X-Backend"), "path=%s", tc.path)
+		s.Equalf(tc.routeHeader, result.Header.Get("X-Route-Header"), "path=%s", tc.path)
+		s.Equal(tc.path, result.Header.Get("X-Path"))
+	}
+}
+
+func (s *RouteTestSuite) TestRegexOverlappingPrefix() {
+	b, err := NewBackend("http://localhost", nil)
+	s.Require().NoError(err)
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	for _, rd := range []struct{ match, path string }{
+		{"prefix", "/"},
+		{"prefix", "/blog"},
+		{"regex", `\.php$`},
+		{"exact", "/blog/index.php"},
+	} {
+		rt, err := NewRoute(rd.match, rd.path, b, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+		s.Require().NoError(p.AddRoute(rt))
+	}
+
+	// Regular expression is reachable despite of the prefixes covering it
+	for path, expected := range map[string]string{
+		"/index.php":      `\.php$`,
+		"/blog/post.php":  `\.php$`,
+		"/blog/index.php": "/blog/index.php",
+		"/blog/post":      "/blog",
+		"/about":          "/",
+	} {
+		rt := p.route(path)
+		s.Require().NotNil(rt, path)
+		s.Equal(expected, rt.Path, path)
+	}
+}
+
+func TestRouteTestSuite(t *testing.T) {
+	suite.Run(t, new(RouteTestSuite))
+}
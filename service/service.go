@@ -1,13 +1,21 @@
 package service
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/teran/svcproxy/middleware/types"
 )
 
 var _ Service = &Svc{}
@@ -15,32 +23,189 @@ var _ http.Handler = &Svc{}
 
 // Svc implement service
 type Svc struct {
-	proxies map[string]*Proxy
+	// inflight is accessed atomically so it must be the first field
+	// to be 64-bit aligned on 32-bit platforms
+	inflight     int64
+	shuttingDown int32
+
+	mutex     sync.RWMutex
+	services  map[string][]*Proxy
+	proxies   map[string]*Proxy
+	wildcards map[string]*Proxy
+	reload    func() error
+	build     ServiceBuilder
+	// adminToken authorizes admin API requests changing services
+	adminToken string
+	// runtime is the state of services changed via admin API
+	runtime map[string]*runtimeState
+	// errorPages are used for services without their own pages
+	errorPages *ErrorPages
+	// defaultService and fallbackCertificate handle unknown hosts
+	defaultService      *DefaultService
+	fallbackCertificate *tls.Certificate
 }
 
 // NewService returns new service instance
 func NewService() (*Svc, error) {
 	return &Svc{
-		proxies: make(map[string]*Proxy),
+		services:  make(map[string][]*Proxy),
+		proxies:   make(map[string]*Proxy),
+		wildcards: make(map[string]*Proxy),
+		runtime:   make(map[string]*runtimeState),
 	}, nil
 }
 
-// AddProxy adds proxy to the service
+// AddProxy adds proxy to the service. Proxies without service name
+// are registered as separate services named by their FQDN.
 func (s *Svc) AddProxy(p *Proxy) error {
-	s.proxies[strings.ToLower(p.Frontend.FQDN)] = p
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if p.Service == "" {
+		p.Service = p.Frontend.FQDN
+	}
+
+	if err := addProxy(s.proxies, s.wildcards, p); err != nil {
+		return err
+	}
+	s.services[p.Service] = append(s.services[p.Service], p)
 	return nil
 }
 
+// SetProxies atomically replaces all the proxies of the service.
+// Proxies are left untouched if any of new ones is invalid or serves
+// the host of another one. Backends of replaced proxies are closed.
+// Services added via admin API
+// are kept unless new proxies take their names or hosts, drain and
+// maintenance modes set via admin API are kept as well.
+func (s *Svc) SetProxies(proxies []*Proxy) error {
+	newServices := make(map[string][]*Proxy)
+	newProxies := make(map[string]*Proxy)
+	newWildcards := make(map[string]*Proxy)
+	for _, p := range proxies {
+		if p.Service == "" {
+			p.Service = p.Frontend.FQDN
+		}
+		if err := addProxy(newProxies, newWildcards, p); err != nil {
+			return err
+		}
+		newServices[p.Service] = append(newServices[p.Service], p)
+	}
+
+	s.mutex.Lock()
+	kept := s.keepRuntimeServices(newServices, newProxies, newWildcards)
+
+	var oldProxies []*Proxy
+	for name, sp := range s.services {
+		if !kept[name] {
+			oldProxies = append(oldProxies, sp...)
+		}
+	}
+	s.services = newServices
+	s.proxies = newProxies
+	s.wildcards = newWildcards
+	s.mutex.Unlock()
+
+	CloseProxies(oldProxies)
+	return nil
+}
+
+// Proxies returns list of the proxies served
+func (s *Svc) Proxies() []*Proxy {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.proxiesList()
+}
+
+func (s *Svc) proxiesList() []*Proxy {
+	var proxies []*Proxy
+	for _, sp := range s.services {
+		proxies = append(proxies, sp...)
+	}
+	return proxies
+}
+
+// SetReloadFunc sets the function called to reload configuration
+// from debug listener
+func (s *Svc) SetReloadFunc(reload func() error) {
+	s.reload = reload
+}
+
+// CloseProxies closes backends used by the proxies passed
+func CloseProxies(proxies []*Proxy) {
+	closed := make(map[*Backend]bool)
+	closeBackend := func(b *Backend) {
+		if !closed[b] {
+			b.Close()
+			closed[b] = true
+		}
+	}
+
+	for _, p := range proxies {
+		closeBackend(p.Backend)
+		for _, rt := range p.Routes {
+			closeBackend(rt.Backend)
+		}
+		for _, g := range p.Groups {
+			closeBackend(g.Backend)
+		}
+		if p.mirror != nil {
+			closeBackend(p.mirror.Backend)
+		}
+	}
+}
+
+func addProxy(proxies, wildcards map[string]*Proxy, p *Proxy) error {
+	fqdn, err := NormalizeHost(p.Frontend.FQDN)
+	if err != nil {
+		return err
+	}
+	if owner, ok := hostOwner(proxies, wildcards, fqdn); ok {
+		return fmt.Errorf("%s: `%s` is served by `%s`", ErrHostConflict, fqdn, owner.Service)
+	}
+
+	addHost(proxies, wildcards, fqdn, p)
+	return nil
+}
+
+// addHost registers proxy for normalized host name
+func addHost(proxies, wildcards map[string]*Proxy, fqdn string, p *Proxy) {
+	if strings.HasPrefix(fqdn, "*.") {
+		wildcards[fqdn[2:]] = p
+		return
+	}
+	proxies[fqdn] = p
+}
+
 func (s *Svc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Upgraded connections are tracked as well since handler
+	// doesn't return until they're closed
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+
 	hostName := strings.ToLower(r.Host)
-	p, ok := s.proxies[hostName]
+	p, ok := s.lookup(hostName)
 	if !ok {
-		http.NotFound(w, r)
+		if p = s.defaultProxy(w, r); p == nil {
+			return
+		}
+	}
+
+	st := errorPageStateFromRequest(r)
+	if st != nil {
+		st.proxy = p
+	}
+
+	if p.Draining() {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "service is draining", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Handle plain HTTP requests
-	if r.TLS == nil {
+	// Handle plain HTTP requests, the scheme reported by trusted
+	// proxies is taken into account
+	if clientFromRequest(r).Proto == "http" {
 		switch p.Frontend.HTTPHandler {
 		case "reject":
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
@@ -65,6 +230,10 @@ func (s *Svc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if p.serveMaintenance(w, r) {
+		return
+	}
+
 	if p.Authenticator != nil {
 		if !p.Authenticator.IsAuthenticated(r) {
 			p.Authenticator.Authenticate(w, r)
@@ -72,13 +241,53 @@ func (s *Svc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	for k, v := range p.Frontend.ResponseHTTPHeaders {
-		w.Header().Set(k, v)
+	// Response headers are set on backend responses since the ones
+	// set here would be merged with backend ones
+	r = withRequestVars(r, p)
+	if st != nil {
+		st.vars = requestVarsFromRequest(r)
+	}
+
+	p.mirror.send(p.Service, r)
+
+	if rt := p.route(r.URL.Path); rt != nil {
+		rt.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	if g := p.group(r); g != nil {
+		if stats := types.RequestStatsFromContext(r.Context()); stats != nil {
+			stats.SetBackendGroup(g.Name)
+		}
+		g.proxy.ServeHTTP(w, r)
+		return
 	}
 
 	p.proxy.ServeHTTP(w, r)
 }
 
+// Shutdown makes readiness endpoint fail so no new traffic
+// is routed to the instance
+func (s *Svc) Shutdown() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+// Wait blocks until all requests in flight are completed
+// or context is done
+func (s *Svc) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&s.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 // DebugHandlerFunc implements handlers for debug listener
 func (s *Svc) DebugHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	mux := http.NewServeMux()
@@ -90,14 +299,40 @@ func (s *Svc) DebugHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	mux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
 	mux.Handle("/health/metrics", promhttp.Handler())
 	mux.Handle("/health/ping", http.HandlerFunc(s.debugPing))
+	mux.Handle("/config/reload", http.HandlerFunc(s.debugReload))
+	mux.Handle("/admin/services", http.HandlerFunc(s.adminServices))
+	mux.Handle("/admin/services/", http.HandlerFunc(s.adminService))
 
 	mux.ServeHTTP(w, r)
 }
 
 func (s *Svc) debugPing(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	w.Write([]byte("pong"))
 }
 
+func (s *Svc) debugReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.reload == nil {
+		http.Error(w, "configuration reload is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	if err := s.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Write([]byte("reloaded"))
+}
+
 func singleJoiningSlash(a, b string) string {
 	aslash := strings.HasSuffix(a, "/")
 	bslash := strings.HasPrefix(b, "/")
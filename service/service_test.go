@@ -1,9 +1,12 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
@@ -174,6 +177,143 @@ func (s *ServiceTestSuite) TestHeadersPassedToBackend() {
 	s.Equal(http.StatusNoContent, result.StatusCode)
 }
 
+func (s *ServiceTestSuite) TestSetProxies() {
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer testsrv.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	newProxy := func(fqdn string) *Proxy {
+		f, err := NewFrontend(fqdn, "proxy", nil)
+		s.Require().NoError(err)
+
+		b, err := NewBackend(testsrv.URL, nil)
+		s.Require().NoError(err)
+
+		p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+		return p
+	}
+
+	serve := func(host string) int {
+		r, err := http.NewRequest("GET", "http://"+host+"/", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, r)
+		return w.Result().StatusCode
+	}
+
+	err = svc.SetProxies([]*Proxy{newProxy("old.local")})
+	s.Require().NoError(err)
+	s.Equal(http.StatusNoContent, serve("old.local"))
+
+	err = svc.SetProxies([]*Proxy{newProxy("new.local"), newProxy("*.new.local")})
+	s.Require().NoError(err)
+	s.Equal(http.StatusNotFound, serve("old.local"))
+	s.Equal(http.StatusNoContent, serve("new.local"))
+	s.Equal(http.StatusNoContent, serve("sub.new.local"))
+	s.Len(svc.Proxies(), 2)
+
+	// Ambiguous hosts are rejected keeping running proxies
+	first, second := newProxy("dup.local"), newProxy("DUP.local")
+	first.Service, second.Service = "first", "second"
+	err = svc.SetProxies([]*Proxy{newProxy("other.local"), first, second})
+	s.Require().Error(err)
+	s.Contains(err.Error(), ErrHostConflict.Error())
+	s.Equal(http.StatusNoContent, serve("new.local"))
+	s.Equal(http.StatusNotFound, serve("other.local"))
+}
+
+func (s *ServiceTestSuite) TestDebugReload() {
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	reload := func(method string) *http.Response {
+		r, err := http.NewRequest(method, "http://localhost/config/reload", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		svc.DebugHandlerFunc(w, r)
+		return w.Result()
+	}
+
+	s.Equal(http.StatusNotImplemented, reload("POST").StatusCode)
+
+	var reloadErr error
+	calls := 0
+	svc.SetReloadFunc(func() error {
+		calls++
+		return reloadErr
+	})
+
+	s.Equal(http.StatusMethodNotAllowed, reload("GET").StatusCode)
+	s.Equal(http.StatusOK, reload("POST").StatusCode)
+
+	reloadErr = fmt.Errorf("invalid configuration")
+	s.Equal(http.StatusUnprocessableEntity, reload("POST").StatusCode)
+	s.Equal(2, calls)
+}
+
+func (s *ServiceTestSuite) TestShutdown() {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	testsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer testsrv.Close()
+
+	svc, err := NewService()
+	s.Require().NoError(err)
+
+	f, err := NewFrontend("test.local", "proxy", nil)
+	s.Require().NoError(err)
+
+	b, err := NewBackend(testsrv.URL, nil)
+	s.Require().NoError(err)
+
+	p, err := NewProxy(f, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+
+	svc.AddProxy(p)
+
+	ping := func() int {
+		r, err := http.NewRequest("GET", "http://localhost/health/ping", nil)
+		s.Require().NoError(err)
+
+		w := httptest.NewRecorder()
+		svc.DebugHandlerFunc(w, r)
+		return w.Result().StatusCode
+	}
+
+	done := make(chan int)
+	go func() {
+		r, _ := http.NewRequest("GET", "http://test.local/", nil)
+		w := httptest.NewRecorder()
+		svc.ServeHTTP(w, r)
+		done <- w.Result().StatusCode
+	}()
+
+	<-arrived
+
+	s.Equal(http.StatusOK, ping())
+	svc.Shutdown()
+	s.Equal(http.StatusServiceUnavailable, ping())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s.Equal(context.DeadlineExceeded, svc.Wait(ctx))
+
+	close(release)
+	s.Equal(http.StatusNoContent, <-done)
+	s.NoError(svc.Wait(context.Background()))
+}
+
 func TestServiceTestSuite(t *testing.T) {
 	suite.Run(t, new(ServiceTestSuite))
 }
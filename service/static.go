@@ -0,0 +1,303 @@
+package service
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StaticOptions defines how files of `file://` targets are served
+type StaticOptions struct {
+	// IndexFiles are looked up in directories, index.html by default
+	IndexFiles []string
+	// SPAFallback serves index file of the root directory for the paths
+	// not found, e.g. for single page applications routing on client side
+	SPAFallback bool
+	// DirectoryListing lists directories without index files, they're
+	// not found otherwise
+	DirectoryListing bool
+	// Precompressed serves `.br` and `.gz` files placed next to the
+	// requested one to clients accepting such encodings
+	Precompressed bool
+}
+
+// precompressedEncodings are content encodings of precompressed files
+// in the order of preference
+var precompressedEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// SetStatic sets options of serving files for `file://` targets
+// of the backend
+func (b *Backend) SetStatic(opts *StaticOptions) error {
+	for _, name := range opts.IndexFiles {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid index file name: `%s`", name)
+		}
+	}
+
+	for _, t := range b.Targets {
+		if t.static != nil {
+			t.static = &handlerTransport{handler: newStaticHandler(t.URL.Path, opts)}
+		}
+	}
+	return nil
+}
+
+// staticHandler serves files of the root directory
+type staticHandler struct {
+	root string
+	dir  http.Dir
+	opts StaticOptions
+}
+
+func newStaticHandler(root string, opts *StaticOptions) *staticHandler {
+	h := &staticHandler{
+		root: root,
+		dir:  http.Dir(filepath.FromSlash(root)),
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if len(h.opts.IndexFiles) == 0 {
+		h.opts.IndexFiles = []string{"index.html"}
+	}
+	return h
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Request path is joined with the root by target, path is cleaned
+	// so it can't point outside of the root
+	upath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, h.root))
+
+	f, err := h.dir.Open(upath)
+	if err != nil {
+		h.notFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		h.notFound(w, r)
+		return
+	}
+
+	if !fi.IsDir() {
+		h.serveFile(w, r, upath, f, fi)
+		return
+	}
+
+	// Relative links in directory index are resolved against the path
+	// with trailing slash
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := "./" + path.Base(r.URL.Path) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	if h.serveIndex(w, r, upath) {
+		return
+	}
+
+	if h.opts.DirectoryListing {
+		h.listDirectory(w, f)
+		return
+	}
+	h.notFound(w, r)
+}
+
+// serveIndex serves index file of the directory if any
+func (h *staticHandler) serveIndex(w http.ResponseWriter, r *http.Request, dir string) bool {
+	for _, name := range h.opts.IndexFiles {
+		name = path.Join(dir, name)
+
+		f, err := h.dir.Open(name)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		h.serveFile(w, r, name, f, fi)
+		return true
+	}
+	return false
+}
+
+func (h *staticHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	if h.opts.SPAFallback && h.serveIndex(w, r, "/") {
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+// serveFile serves the file or its precompressed version handling
+// conditional and range requests
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, f http.File, fi os.FileInfo) {
+	ctype := mime.TypeByExtension(path.Ext(name))
+
+	var content io.ReadSeeker = f
+	if h.opts.Precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		for _, pe := range precompressedEncodings {
+			if acceptQuality(r.Header.Get("Accept-Encoding"), pe.encoding) <= 0 {
+				continue
+			}
+
+			cf, err := h.dir.Open(name + pe.ext)
+			if err != nil {
+				continue
+			}
+			defer cf.Close()
+
+			cfi, err := cf.Stat()
+			if err != nil || cfi.IsDir() {
+				continue
+			}
+
+			// Content type can't be sniffed from compressed content
+			if ctype == "" {
+				ctype = "application/octet-stream"
+			}
+			w.Header().Set("Content-Encoding", pe.encoding)
+			content, fi = cf, cfi
+			break
+		}
+	}
+
+	if ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("ETag", fileETag(fi))
+
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// fileETag returns strong ETag built of file modification time and size
+func fileETag(fi os.FileInfo) string {
+	return `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(fi.Size(), 36) + `"`
+}
+
+func (h *staticHandler) listDirectory(w http.ResponseWriter, f http.File) {
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		http.Error(w, "error reading directory", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, "<pre>\n")
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(name))
+	}
+	io.WriteString(w, "</pre>\n")
+}
+
+// handlerTransport passes requests to the handler in process, response
+// body is streamed from the handler while it's read
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (ht *handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	w := &pipeResponseWriter{
+		r:      r,
+		header: make(http.Header),
+		body:   pr,
+		pipe:   pw,
+		ready:  make(chan *http.Response, 1),
+	}
+
+	go func() {
+		defer pw.Close()
+		defer w.WriteHeader(http.StatusOK)
+
+		ht.handler.ServeHTTP(w, r)
+	}()
+	return <-w.ready, nil
+}
+
+// pipeResponseWriter makes response of the data written by handler
+type pipeResponseWriter struct {
+	r      *http.Request
+	header http.Header
+	body   *io.PipeReader
+	pipe   *io.PipeWriter
+	ready  chan *http.Response
+
+	once sync.Once
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		contentLength := int64(-1)
+		if v, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil {
+			contentLength = v
+		}
+
+		header := make(http.Header, len(w.header))
+		for k, v := range w.header {
+			header[k] = v
+		}
+
+		w.ready <- &http.Response{
+			Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          w.body,
+			ContentLength: contentLength,
+			Request:       w.r,
+		}
+	})
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.r.Method == "HEAD" {
+		return len(b), nil
+	}
+	return w.pipe.Write(b)
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type StaticTestSuite struct {
+	suite.Suite
+
+	base string
+	root string
+}
+
+func (s *StaticTestSuite) SetupTest() {
+	base, err := ioutil.TempDir("", "svcproxy-static")
+	s.Require().NoError(err)
+	s.base = base
+	// Files outside of the root must not be served
+	root := filepath.Join(base, "site")
+	s.root = root
+
+	files := map[string]string{
+		"index.html":        "<h1>index</h1>",
+		"app.js":            "console.log(1)",
+		"app.js.gz":         "gzipped",
+		"app.js.br":         "brotli",
+		"docs/readme.txt":   "0123456789",
+		"assets/logo.svg":   "<svg/>",
+		"../outside.txt":    "secret",
+		"empty/.gitkeep":    "",
+		"nested/index.html": "nested",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		s.Require().NoError(os.MkdirAll(filepath.Dir(name), 0755))
+		s.Require().NoError(ioutil.WriteFile(name, []byte(content), 0644))
+	}
+}
+
+func (s *StaticTestSuite) TearDownTest() {
+	os.RemoveAll(s.base)
+}
+
+func (s *StaticTestSuite) TestFiles() {
+	p := s.proxy(nil)
+
+	w := s.serve(p, "/", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	s.Equal("<h1>index</h1>", w.Body.String())
+
+	w = s.serve(p, "/nested/", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("nested", w.Body.String())
+
+	w = s.serve(p, "/nested", nil)
+	s.Equal(http.StatusMovedPermanently, w.Code)
+	s.Equal("./nested/", w.Header().Get("Location"))
+
+	w = s.serve(p, "/app.js", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("console.log(1)", w.Body.String())
+	s.Empty(w.Header().Get("Content-Encoding"))
+
+	// Directory listing is off by default
+	s.Equal(http.StatusNotFound, s.serve(p, "/empty/", nil).Code)
+	s.Equal(http.StatusNotFound, s.serve(p, "/missing", nil).Code)
+	s.Equal(http.StatusNotFound, s.serve(p, "/../outside.txt", nil).Code)
+
+	r := httptest.NewRequest("POST", "http://test.local/", nil)
+	w = httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, r)
+	s.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+func (s *StaticTestSuite) TestConditionalAndRange() {
+	p := s.proxy(nil)
+
+	w := s.serve(p, "/docs/readme.txt", nil)
+	s.Equal(http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	s.NotEmpty(etag)
+	lastModified := w.Header().Get("Last-Modified")
+	s.NotEmpty(lastModified)
+
+	w = s.serve(p, "/docs/readme.txt", http.Header{"If-None-Match": {etag}})
+	s.Equal(http.StatusNotModified, w.Code)
+
+	w = s.serve(p, "/docs/readme.txt", http.Header{"If-Modified-Since": {lastModified}})
+	s.Equal(http.StatusNotModified, w.Code)
+
+	w = s.serve(p, "/docs/readme.txt", http.Header{"Range": {"bytes=2-5"}})
+	s.Equal(http.StatusPartialContent, w.Code)
+	s.Equal("bytes 2-5/10", w.Header().Get("Content-Range"))
+	s.Equal("2345", w.Body.String())
+}
+
+func (s *StaticTestSuite) TestPrecompressed() {
+	p := s.proxy(&StaticOptions{Precompressed: true})
+
+	w := s.serve(p, "/app.js", http.Header{"Accept-Encoding": {"gzip, br"}})
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("br", w.Header().Get("Content-Encoding"))
+	s.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	s.Contains(w.Header().Get("Content-Type"), "javascript")
+	s.Equal("brotli", w.Body.String())
+
+	w = s.serve(p, "/app.js", http.Header{"Accept-Encoding": {"gzip, br;q=0"}})
+	s.Equal("gzip", w.Header().Get("Content-Encoding"))
+	s.Equal("gzipped", w.Body.String())
+
+	w = s.serve(p, "/app.js", nil)
+	s.Empty(w.Header().Get("Content-Encoding"))
+	s.Equal("console.log(1)", w.Body.String())
+}
+
+func (s *StaticTestSuite) TestSPAFallbackAndListing() {
+	p := s.proxy(&StaticOptions{SPAFallback: true, DirectoryListing: true})
+
+	w := s.serve(p, "/users/42", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("<h1>index</h1>", w.Body.String())
+
+	w = s.serve(p, "/assets/", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Contains(w.Body.String(), `<a href="logo.svg">logo.svg</a>`)
+}
+
+func (s *StaticTestSuite) TestIndexFiles() {
+	b, err := NewBackend("file://"+filepath.ToSlash(s.root), nil)
+	s.Require().NoError(err)
+	s.Error(b.SetStatic(&StaticOptions{IndexFiles: []string{"../index.html"}}))
+
+	p := s.proxy(&StaticOptions{IndexFiles: []string{"readme.txt"}})
+	w := s.serve(p, "/docs/", nil)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("0123456789", w.Body.String())
+}
+
+func (s *StaticTestSuite) TestTargetValidation() {
+	_, err := NewTarget("file://remote/srv", 1)
+	s.Error(err)
+	_, err = NewTarget("file:relative", 1)
+	s.Error(err)
+}
+
+func (s *StaticTestSuite) proxy(opts *StaticOptions) *Proxy {
+	b, err := NewBackend("file://"+filepath.ToSlash(s.root), nil)
+	s.Require().NoError(err)
+	if opts != nil {
+		s.Require().NoError(b.SetStatic(opts))
+	}
+
+	p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+	s.Require().NoError(err)
+	return p
+}
+
+func (s *StaticTestSuite) serve(p *Proxy, path string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://test.local/", nil)
+	r.URL.Path = path
+	for k, v := range header {
+		r.Header[k] = v
+	}
+
+	w := httptest.NewRecorder()
+	p.proxy.ServeHTTP(w, r)
+	return w
+}
+
+func TestStaticTestSuite(t *testing.T) {
+	suite.Run(t, new(StaticTestSuite))
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrNoTargetsAvailable is returned when balancer can't choose
+// the target to pass request to
+var ErrNoTargetsAvailable = errors.New("no backend targets available")
+
+// NewTarget creates new Target instance
+func NewTarget(address string, weight int) (*Target, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if weight < 0 {
+		return nil, fmt.Errorf("target weight must not be negative: %d", weight)
+	}
+	if weight == 0 {
+		weight = 1
+	}
+
+	t := &Target{
+		URL:      u,
+		Weight:   weight,
+		id:       targetID(u.String()),
+		upstream: u,
+	}
+
+	switch u.Scheme {
+	case "file":
+		if (u.Host != "" && u.Host != "localhost") || !strings.HasPrefix(u.Path, "/") {
+			return nil, fmt.Errorf("file target must point to absolute local path: `%s`", address)
+		}
+		t.static = &handlerTransport{handler: newStaticHandler(u.Path, nil)}
+	case "unix":
+		t.socket, t.upstream, err = parseUnixTarget(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Outstanding returns amount of requests currently passed to the target
+// and not completed yet
+func (t *Target) Outstanding() int64 {
+	return atomic.LoadInt64(&t.outstanding)
+}
+
+// rewriteURL points request URL to the target
+func (t *Target) rewriteURL(r *http.Request) {
+	u := t.upstream
+	r.URL.Scheme = u.Scheme
+	r.URL.Host = u.Host
+	r.URL.Path, r.URL.RawPath = joinURLPath(u, r.URL)
+	if u.RawQuery == "" || r.URL.RawQuery == "" {
+		r.URL.RawQuery = u.RawQuery + r.URL.RawQuery
+	} else {
+		r.URL.RawQuery = u.RawQuery + "&" + r.URL.RawQuery
+	}
+}
+
+// joinURLPath joins paths of the URLs keeping encoded ones
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+// backendTransport passes requests to the targets chosen by backend's balancer
+type backendTransport struct {
+	backend *Backend
+	next    http.RoundTripper
+}
+
+func (bt *backendTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if bt.backend.retryPolicy != nil {
+		return bt.roundTripWithRetries(r, bt.backend.retryPolicy)
+	}
+
+	t := bt.pick(r, nil)
+	if t == nil {
+		return nil, ErrNoTargetsAvailable
+	}
+	return bt.try(r, t, nil)
+}
+
+// pick chooses the target for request avoiding already tried ones if possible
+func (bt *backendTransport) pick(r *http.Request, tried map[*Target]bool) *Target {
+	available := bt.backend.available()
+	if len(tried) > 0 {
+		var fresh []*Target
+		for _, t := range available {
+			if !tried[t] {
+				fresh = append(fresh, t)
+			}
+		}
+		if len(fresh) > 0 {
+			available = fresh
+		}
+	}
+	if t := bt.backend.affinity.target(r, available); t != nil {
+		return t
+	}
+	return bt.backend.balancer.Next(r, available)
+}
+
+// try passes request to the particular target, release is called
+// once target is done with the request
+func (bt *backendTransport) try(r *http.Request, t *Target, release func()) (*http.Response, error) {
+	outreq := new(http.Request)
+	*outreq = *r
+	u := *r.URL
+	outreq.URL = &u
+	t.rewriteURL(outreq)
+
+	t.circuit.acquire()
+	atomic.AddInt64(&t.outstanding, 1)
+	done := func() {
+		atomic.AddInt64(&t.outstanding, -1)
+		if release != nil {
+			release()
+		}
+	}
+
+	next := bt.next
+	if bt.backend.transport != nil {
+		// PROXY protocol header is used only if the transport dials
+		// with it, it's ignored by TLS settings alone
+		next = bt.backend.transport
+		outreq = withProxyHeader(outreq)
+	}
+	next = t.roundTripper(next)
+
+	resp, err := next.RoundTrip(outreq)
+	if err != nil {
+		done()
+		// Requests cancelled by clients say nothing about target state
+		if r.Context().Err() == nil || r.Context().Err() == context.DeadlineExceeded {
+			t.circuit.report(false)
+		} else {
+			t.circuit.release()
+		}
+		return nil, err
+	}
+	t.circuit.report(resp.StatusCode < 500)
+	bt.backend.affinity.stick(r, resp, t)
+
+	// Upgraded connections(i.e. WebSocket) are expected to have writable body
+	if rwc, ok := resp.Body.(io.ReadWriteCloser); ok {
+		resp.Body = &targetUpgradedBody{Writer: rwc, targetBody: targetBody{ReadCloser: rwc, done: done}}
+		return resp, nil
+	}
+	resp.Body = &targetBody{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+// targetBody tracks the moment response is completely passed to client
+type targetBody struct {
+	io.ReadCloser
+	done   func()
+	closed int32
+}
+
+func (tb *targetBody) Close() error {
+	err := tb.ReadCloser.Close()
+	if atomic.CompareAndSwapInt32(&tb.closed, 0, 1) {
+		tb.done()
+	}
+	return err
+}
+
+// targetUpgradedBody is targetBody for upgraded connections
+type targetUpgradedBody struct {
+	io.Writer
+	targetBody
+}
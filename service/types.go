@@ -1,8 +1,11 @@
 package service
 
 import (
+	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"sync"
 
 	"github.com/teran/svcproxy/authentication"
 )
@@ -14,10 +17,39 @@ type Service interface {
 
 // Proxy type
 type Proxy struct {
+	// Service is the name of the service proxy belongs to,
+	// proxies of the same service share backends
+	Service       string
 	Frontend      *Frontend
 	Backend       *Backend
+	Routes        []*Route
+	Groups        []*BackendGroup
+	overrides     []groupOverride
+	mirror        *mirror
 	proxy         *httputil.ReverseProxy
 	Authenticator authentication.Authenticator
+	errorPages    *ErrorPages
+	maintenance   *Maintenance
+	draining      int32
+	// maintenanceMode is toggled at runtime
+	maintenanceMode int32
+}
+
+// Route type
+type Route struct {
+	Match   RouteMatch
+	Path    string
+	Backend *Backend
+	pattern *regexp.Regexp
+	proxy   *httputil.ReverseProxy
+}
+
+// BackendGroup type
+type BackendGroup struct {
+	Name    string
+	Weight  int
+	Backend *Backend
+	proxy   *httputil.ReverseProxy
 }
 
 // Frontend type
@@ -25,10 +57,53 @@ type Frontend struct {
 	FQDN                string
 	HTTPHandler         string
 	ResponseHTTPHeaders map[string]string
+	responseHeaders     []HeaderRule
 }
 
 // Backend type
 type Backend struct {
+	// URL of the first target, kept for the backends with single target
 	URL                *url.URL
+	Targets            []*Target
+	balancer           Balancer
 	requestHTTPHeaders map[string]string
+	requestHeaders     []HeaderRule
+	healthCheck        *HealthCheck
+	healthChecks       *sync.WaitGroup
+	stopHealthChecks   chan struct{}
+	retryPolicy        *RetryPolicy
+	retryBudget        *retryBudget
+	affinity           *affinity
+	rewrite            *Rewrite
+	responseRewrite    *ResponseRewrite
+	// transport replaces the one passed to proxy if backend
+	// requires special connection handling
+	transport http.RoundTripper
+	// metrics is set once target metrics are exposed by the backend
+	metrics bool
+}
+
+// Target type
+type Target struct {
+	// outstanding is accessed atomically so it must be the first field
+	// to be 64-bit aligned on 32-bit platforms
+	outstanding int64
+	unhealthy   int32
+
+	URL     *url.URL
+	Weight  int
+	id      string
+	circuit *circuit
+	// service is the name of the service target metrics are labelled with
+	service string
+	// upstream is the URL requests are passed by, it differs from
+	// URL for `unix://` targets
+	upstream *url.URL
+	// static serves files of `file://` targets
+	static http.RoundTripper
+	// socket is the path of `unix://` target socket dialed by
+	// the transport created on first request
+	socket   string
+	unixOnce sync.Once
+	unix     http.RoundTripper
 }
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// unixSocketHost is the host of URLs requests to Unix socket targets are
+// passed by, each target has its own transport so connections to different
+// sockets are not mixed. Host header of proxied requests is kept intact.
+const unixSocketHost = "localhost"
+
+// parseUnixTarget splits URL like `unix:///run/app.sock:/prefix` into
+// socket path and the URL requests are passed by
+func parseUnixTarget(u *url.URL) (string, *url.URL, error) {
+	if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return "", nil, fmt.Errorf("unix target must point to absolute socket path: `%s`", u)
+	}
+
+	socket, prefix := u.Path, ""
+	if i := strings.Index(u.Path, ":"); i >= 0 {
+		socket, prefix = u.Path[:i], u.Path[i+1:]
+	}
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return "", nil, fmt.Errorf("unix target path prefix must be absolute: `%s`", u)
+	}
+
+	return socket, &url.URL{
+		Scheme:   "http",
+		Host:     unixSocketHost,
+		Path:     prefix,
+		RawQuery: u.RawQuery,
+	}, nil
+}
+
+// newUnixTransport returns copy of the transport dialing the socket,
+// dial function of the transport is used so connection wrappers like
+// PROXY protocol keep working
+func newUnixTransport(socket string, base http.RoundTripper) http.RoundTripper {
+	bt, ok := base.(*http.Transport)
+	if !ok {
+		bt = &http.Transport{}
+	}
+
+	dial := bt.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	t := bt.Clone()
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dial(ctx, "unix", socket)
+	}
+	return t
+}
+
+// roundTripper returns transport passing requests to the target,
+// the base one is used for HTTP targets
+func (t *Target) roundTripper(base http.RoundTripper) http.RoundTripper {
+	switch {
+	case t.static != nil:
+		return t.static
+	case t.socket != "":
+		t.unixOnce.Do(func() {
+			t.unix = newUnixTransport(t.socket, base)
+		})
+		return t.unix
+	}
+	return base
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type UnixTestSuite struct {
+	suite.Suite
+
+	dir    string
+	socket string
+	server *http.Server
+	hosts  chan string
+}
+
+func (s *UnixTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "svcproxy-unix")
+	s.Require().NoError(err)
+	s.dir = dir
+	s.socket = filepath.Join(dir, "app.sock")
+
+	l, err := net.Listen("unix", s.socket)
+	s.Require().NoError(err)
+
+	s.hosts = make(chan string, 10)
+	s.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.hosts <- r.Host:
+		default:
+		}
+		fmt.Fprintf(w, "%s %s", r.Host, r.URL.RequestURI())
+	})}
+	go s.server.Serve(l)
+}
+
+func (s *UnixTestSuite) TearDownTest() {
+	s.server.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *UnixTestSuite) TestProxy() {
+	for address, expected := range map[string]string{
+		"unix://" + s.socket:              "test.local /path?q=1",
+		"unix://" + s.socket + ":/prefix": "test.local /prefix/path?q=1",
+	} {
+		b, err := NewBackend(address, nil)
+		s.Require().NoError(err)
+		p, err := NewProxy(&Frontend{FQDN: "test.local"}, b, nil, http.DefaultTransport, nil)
+		s.Require().NoError(err)
+
+		r := httptest.NewRequest("GET", "http://test.local/path?q=1", nil)
+		w := httptest.NewRecorder()
+		p.proxy.ServeHTTP(w, r)
+
+		s.Equal(http.StatusOK, w.Code, address)
+		s.Equal(expected, w.Body.String(), address)
+	}
+}
+
+func (s *UnixTestSuite) TestHealthCheck() {
+	for _, checkType := range []string{"http", "tcp"} {
+		b, err := NewBackend("unix://"+s.socket, nil)
+		s.Require().NoError(err)
+
+		// Target recovers once socket is checked
+		b.Targets[0].setHealthy(false)
+		s.Require().NoError(b.SetHealthCheck(&HealthCheck{
+			Type:     checkType,
+			Interval: 10 * time.Millisecond,
+		}, http.DefaultTransport))
+
+		for i := 0; i < 100 && !b.Targets[0].Healthy(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		s.True(b.Targets[0].Healthy(), checkType)
+		b.Close()
+	}
+
+	// HTTP health checks are passed with local host name
+	s.Equal(unixSocketHost, <-s.hosts)
+}
+
+func (s *UnixTestSuite) TestValidation() {
+	_, err := NewTarget("unix://host/run/app.sock", 1)
+	s.Error(err)
+	_, err = NewTarget("unix:relative.sock", 1)
+	s.Error(err)
+	_, err = NewTarget("unix:///run/app.sock:prefix", 1)
+	s.Error(err)
+
+	t, err := NewTarget("unix:///run/app.sock:/api", 1)
+	s.Require().NoError(err)
+	s.Equal("/run/app.sock", t.socket)
+	s.Equal("http://localhost/api", t.upstream.String())
+}
+
+func TestUnixTestSuite(t *testing.T) {
+	suite.Run(t, new(UnixTestSuite))
+}
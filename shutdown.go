@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/teran/svcproxy/config"
+	"github.com/teran/svcproxy/service"
+)
+
+// waitForShutdown blocks until SIGTERM or SIGINT is received and then
+// gracefully stops the service: readiness endpoint starts to fail, after
+// pre-stop delay service servers stop accepting new connections and
+// requests in flight are drained. Debug server is stopped the last one
+// to keep readiness endpoint and metrics available while draining.
+func waitForShutdown(cfg config.ListenerShutdown, svc *service.Svc, debugSvc *http.Server, servers []*http.Server, closers ...io.Closer) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-ch
+	log.WithFields(log.Fields{
+		"signal": sig.String(),
+	}).Info("Shutting down")
+
+	// Second signal skips draining
+	go func() {
+		sig := <-ch
+		log.WithFields(log.Fields{
+			"signal": sig.String(),
+		}).Warn("Forced shutdown")
+		os.Exit(1)
+	}()
+
+	svc.Shutdown()
+
+	if cfg.PreStopDelay > 0 {
+		log.WithFields(log.Fields{
+			"delay": cfg.PreStopDelay.String(),
+		}).Info("Waiting before stopping listeners")
+		time.Sleep(cfg.PreStopDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			shutdownServer(ctx, srv)
+		}(srv)
+	}
+	wg.Wait()
+
+	// Hijacked connections like WebSocket ones are not tracked by http.Server
+	if err := svc.Wait(ctx); err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+		}).Warn("Error draining upgraded connections. Closing them.")
+	}
+
+	shutdownServer(ctx, debugSvc)
+
+	service.CloseProxies(svc.Proxies())
+
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.WithFields(log.Fields{
+				"reason": err,
+			}).Warn("Error closing connections")
+		}
+	}
+
+	log.Info("Shutdown completed")
+}
+
+func shutdownServer(ctx context.Context, srv *http.Server) {
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"socket": srv.Addr,
+		"reason": err,
+	}).Warn("Error draining connections. Closing them.")
+	srv.Close()
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
-	stdlog "log"
+	"io"
 	"net"
 	"net/http"
 	"os"
@@ -13,10 +14,10 @@ import (
 	"golang.org/x/crypto/acme/autocert"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/teran/svcproxy/authentication/factory"
 	"github.com/teran/svcproxy/autocert/cache"
 	"github.com/teran/svcproxy/config"
 	"github.com/teran/svcproxy/middleware"
+	"github.com/teran/svcproxy/proxyproto"
 	"github.com/teran/svcproxy/service"
 )
 
@@ -51,73 +52,40 @@ func main() {
 	w := logger.Writer()
 	defer w.Close()
 
-	var hostsList []string
-
-	// Fill service instance with proxies
-	for _, sd := range cfg.Services {
-		for _, fqdn := range sd.Frontend.FQDN {
-			f, err := service.NewFrontend(fqdn, sd.Frontend.HTTPHandler, sd.Frontend.ResponseHTTPHeaders)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"reason": err,
-					"object": fqdn,
-				}).Warn("Error: unable to initialize frontend. Skipping.")
-				continue
-			}
-
-			a, err := factory.NewAuthenticator(sd.Authentication.Method, sd.Authentication.Options)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"reason": err,
-					"object": sd.Authentication.Method,
-					"parent": fqdn,
-				}).Warn("Error: unable to initialize auhenticator. Skipping.")
-				continue
-			}
-
-			b, err := service.NewBackend(sd.Backend.URL, sd.Backend.RequestHTTPHeaders)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"reason": err,
-					"object": sd.Backend.URL,
-					"parent": fqdn,
-				}).Warn("Error: unable to initialize backend. Skipping.")
-				continue
-			}
-
-			transport := &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout:   cfg.Listener.Backend.Timeout,
-					KeepAlive: cfg.Listener.Backend.KeepAlive,
-					DualStack: cfg.Listener.Backend.DualStack,
-				}).DialContext,
-				ExpectContinueTimeout: cfg.Listener.Backend.ExpectContinueTimeout,
-				IdleConnTimeout:       cfg.Listener.Backend.IdleConnTimeout,
-				MaxIdleConns:          cfg.Listener.Backend.MaxIdleConns,
-				Proxy:                 http.ProxyFromEnvironment,
-				ResponseHeaderTimeout: cfg.Listener.Backend.ResponseHeaderTimeout,
-				TLSHandshakeTimeout:   cfg.Listener.Backend.TLSHandshakeTimeout,
-			}
-
-			p, err := service.NewProxy(f, b, a, transport, stdlog.New(w, "", 0))
-			if err != nil {
-				log.WithFields(log.Fields{
-					"reason": err,
-					"object": fqdn,
-				}).Warn("Error: unable to register proxy. Skipping.")
-				continue
-			}
-			svc.AddProxy(p)
-
-			hostsList = append(hostsList, fqdn)
-		}
+	proxies, _ := buildProxies(cfg, w)
+	err = svc.SetProxies(proxies)
+	if err != nil {
+		log.Fatalf("Error initializing proxies: %s", err)
+	}
+
+	if err := setErrorPages(svc, cfg.Listener.ErrorPages); err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+		}).Warn("Error: unable to load global error pages. Skipping.")
+	}
+
+	ds, err := buildDefaultService(cfg.Listener, w)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+		}).Warn("Error: unable to initialize default service. Skipping.")
+	}
+	svc.SetDefaultService(ds)
+
+	fallbackCert, err := loadFallbackCertificate(cfg.Listener.FallbackCertificate)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+		}).Warn("Error: unable to load fallback certificate. Skipping.")
 	}
+	svc.SetFallbackCertificate(fallbackCert)
+	svc.SetAdminToken(cfg.Listener.Admin.Token)
 
 	cache := initializeCache(cache.CacheBackend(cfg.Autocert.Cache.Backend), cfg.Autocert.Cache.BackendOptions)
 
 	log.Debug("Loaded proxies for hosts:")
-	for _, host := range hostsList {
-		log.Debugf(" - %s", host)
+	for _, p := range proxies {
+		log.Debugf(" - %s", p.Frontend.FQDN)
 	}
 
 	// Initialize autocert
@@ -126,7 +94,7 @@ func main() {
 		Cache:      cache,
 		Client:     &acme.Client{DirectoryURL: cfg.Autocert.DirectoryURL},
 		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(hostsList...),
+		HostPolicy: svc.HostPolicy,
 	}
 
 	debugSvc := &http.Server{
@@ -138,16 +106,19 @@ func main() {
 			"socket": cfg.Listener.DebugAddr,
 		}).Info("Listening to Debug HTTP socket")
 
-		err = debugSvc.ListenAndServe()
-		log.WithFields(log.Fields{
-			"reason": err,
-		}).Fatal("Error listening Debug HTTP socket")
+		err := debugSvc.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"reason": err,
+			}).Fatal("Error listening Debug HTTP socket")
+		}
 	}()
 
-	httpHandler, err := middleware.Chain(acm.HTTPHandler(svc), cfg.Listener.Middlewares...)
+	httpChain, err := newHandlerChain(cfg.Listener, svc, acm.HTTPHandler(svc))
 	if err != nil {
 		log.Fatalf("error initializing middleware chain for HTTP: %s", err)
 	}
+	httpHandler := newReloadableHandler(httpChain)
 
 	// Run http listeners
 	httpSvc := &http.Server{
@@ -158,15 +129,30 @@ func main() {
 		ReadTimeout:       cfg.Listener.Frontend.ReadTimeout,
 		WriteTimeout:      cfg.Listener.Frontend.WriteTimeout,
 	}
+	// HTTP/2 with prior knowledge is accepted for plaintext gRPC clients
+	// only if asked to
+	if cfg.Listener.Frontend.H2C {
+		httpSvc.Protocols = new(http.Protocols)
+		httpSvc.Protocols.SetHTTP1(true)
+		httpSvc.Protocols.SetUnencryptedHTTP2(true)
+	}
+	httpListener, err := listen(cfg.Listener.HTTPAddr, cfg.Listener.ProxyProtocol)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+		}).Fatal("Error listening Service HTTP socket")
+	}
 	go func() {
 		log.WithFields(log.Fields{
 			"socket": cfg.Listener.HTTPAddr,
 		}).Info("Listening to Service HTTP socket")
 
-		err = httpSvc.ListenAndServe()
-		log.WithFields(log.Fields{
-			"reason": err,
-		}).Fatal("Error listening Service HTTP socket")
+		err := httpSvc.Serve(httpListener)
+		if err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"reason": err,
+			}).Fatal("Error listening Service HTTP socket")
+		}
 	}()
 
 	// Configure TLS
@@ -189,14 +175,29 @@ func main() {
 			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
 		},
-		GetCertificate:           acm.GetCertificate,
+		GetCertificate:           svc.GetCertificate(acm.GetCertificate),
 		PreferServerCipherSuites: true,
 	}
 
-	httpsHandler, err := middleware.Chain(svc, cfg.Listener.Middlewares...)
+	httpsChain, err := newHandlerChain(cfg.Listener, svc, svc)
 	if err != nil {
 		log.Fatalf("error initializing middleware chain for HTTPS: %s", err)
 	}
+	httpsHandler := newReloadableHandler(httpsChain)
+
+	// Configuration is reloaded on SIGHUP or via debug listener
+	rl := &reloader{
+		configPath:   configPath,
+		cfg:          cfg,
+		svc:          svc,
+		acm:          acm,
+		logWriter:    w,
+		httpHandler:  httpHandler,
+		httpsHandler: httpsHandler,
+	}
+	svc.SetReloadFunc(rl.Reload)
+	svc.SetServiceBuilder(rl.BuildService)
+	rl.watchSignals()
 
 	// Run HTTPS listener
 	httpsSvc := &http.Server{
@@ -208,14 +209,31 @@ func main() {
 		ReadTimeout:       cfg.Listener.Frontend.ReadTimeout,
 		WriteTimeout:      cfg.Listener.Frontend.WriteTimeout,
 	}
-	log.WithFields(log.Fields{
-		"socket": cfg.Listener.HTTPSAddr,
-	}).Info("Listening to Service HTTPS socket")
+	httpsListener, err := listen(cfg.Listener.HTTPSAddr, cfg.Listener.ProxyProtocol)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"reason": err,
+		}).Fatal("Error listening HTTPS socket")
+	}
+	go func() {
+		log.WithFields(log.Fields{
+			"socket": cfg.Listener.HTTPSAddr,
+		}).Info("Listening to Service HTTPS socket")
+
+		err := httpsSvc.ServeTLS(httpsListener, "", "")
+		if err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"reason": err,
+			}).Fatal("Error listening HTTPS socket")
+		}
+	}()
 
-	err = httpsSvc.ListenAndServeTLS("", "")
-	log.WithFields(log.Fields{
-		"reason": err,
-	}).Fatal("Error listening HTTPS socket")
+	var closers []io.Closer
+	if c, ok := cache.(io.Closer); ok {
+		closers = append(closers, c)
+	}
+
+	waitForShutdown(cfg.Listener.Shutdown, svc, debugSvc, []*http.Server{httpSvc, httpsSvc}, closers...)
 }
 
 func setLogFormatter(formatter string) {
@@ -231,6 +249,63 @@ func setLogFormatter(formatter string) {
 	}
 }
 
+// listen creates TCP listener accepting PROXY protocol header if enabled
+func listen(addr string, pc config.ListenerProxyProtocol) (net.Listener, error) {
+	// Any client would be able to spoof its address without the list
+	if pc.Enabled && len(pc.TrustedSources) == 0 {
+		return nil, errors.New("proxyProtocol.trustedSources is required when PROXY protocol is enabled")
+	}
+
+	var trusted []*net.IPNet
+	for _, cidr := range pc.TrustedSources {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		trusted = append(trusted, n)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pc.Enabled {
+		return l, nil
+	}
+	return &proxyproto.Listener{
+		Listener:       l,
+		Required:       pc.Required,
+		TrustedSources: trusted,
+		HeaderTimeout:  pc.HeaderTimeout,
+	}, nil
+}
+
+// setErrorPages loads error pages used for all the services
+func setErrorPages(svc *service.Svc, epd *config.ErrorPages) error {
+	ep, err := loadErrorPages(epd)
+	if err != nil {
+		return err
+	}
+	return svc.SetErrorPages(ep)
+}
+
+// newHandlerChain wraps handler with middlewares, client address is
+// resolved before passing request to them and error responses of
+// middlewares and service are replaced by error pages
+func newHandlerChain(lc config.Listener, svc *service.Svc, h http.Handler) (http.Handler, error) {
+	f, err := service.NewForwarding(lc.Forwarding.TrustedProxies, lc.Forwarding.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := middleware.Chain(h, lc.Middlewares...)
+	if err != nil {
+		return nil, err
+	}
+	return f.Handler(service.GRPCErrorsHandler(svc.ErrorPagesHandler(chain))), nil
+}
+
 func initializeCache(backend cache.CacheBackend, options map[string]string) autocert.Cache {
 	// Initialize caching subsystem
 	cache, err := cache.NewCacheFactory(backend, options)